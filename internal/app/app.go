@@ -3,10 +3,19 @@ package app
 import (
 	"context"
 	"github.com/Citadelas/api-gateway/internal/config"
+	"github.com/Citadelas/api-gateway/internal/helpers/validation"
+	"github.com/Citadelas/api-gateway/internal/lib/jwt"
+	"github.com/Citadelas/api-gateway/internal/lib/logger/handlers/slogtrace"
+	"github.com/Citadelas/api-gateway/internal/lib/revocation"
+	"github.com/Citadelas/api-gateway/internal/lib/taskevents"
+	"github.com/Citadelas/api-gateway/internal/lib/taskindex"
+	"github.com/Citadelas/api-gateway/internal/middleware"
 	ssov1 "github.com/Citadelas/protos/golang/sso"
 	taskv1 "github.com/Citadelas/protos/golang/task"
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
 	"log"
 	"log/slog"
 	"net/http"
@@ -27,10 +36,24 @@ type App struct {
 	log        *slog.Logger
 	ssoClient  ssov1.AuthClient
 	taskClient taskv1.TaskServiceClient
-	//ssoConn    *grpc.ClientConn
-	//taskConn   *grpc.ClientConn
-	router *gin.Engine
-	redis  *redis.Client
+	ssoConn    *grpc.ClientConn
+	taskConn   *grpc.ClientConn
+	router     *gin.Engine
+	redis      *redis.Client
+	jwt        *jwt.Validator
+	// denylist holds revoked access tokens.
+	denylist *revocation.Store
+	roles    *middleware.RoleResolver
+	// taskIndex remembers task IDs per user for listing.
+	taskIndex *taskindex.Index
+	// events delivers task events to the streams of this replica.
+	events *taskevents.Hub
+	// stopEvents ends the event hub and with it every open stream.
+	stopEvents context.CancelFunc
+	// stop cancels background workers started by NewApp.
+	stop context.CancelFunc
+	// shutdownTracing flushes pending spans.
+	shutdownTracing func(context.Context) error
 }
 
 func newRedisClient(conn, password string, db int) *redis.Client {
@@ -50,17 +73,49 @@ func NewApp() (*App, error) {
 		slog.Any("cfg", cfg),
 	)
 
+	ctx, stop := context.WithCancel(context.Background())
+	shutdownTracing, err := setupTracing(ctx, cfg.Tracing)
+	if err != nil {
+		stop()
+		return nil, err
+	}
+	if err := redisotel.InstrumentTracing(redisClient); err != nil {
+		stop()
+		return nil, err
+	}
+
 	app := &App{
-		cfg:   cfg,
-		log:   log,
-		redis: redisClient,
+		cfg:             cfg,
+		log:             log,
+		redis:           redisClient,
+		stop:            stop,
+		shutdownTracing: shutdownTracing,
 	}
 
 	if err := app.mustInitClients(); err != nil {
+		stop()
+		return nil, err
+	}
+
+	eventsCtx, stopEvents := context.WithCancel(ctx)
+	app.stopEvents = stopEvents
+	app.events = taskevents.NewHub(log, redisClient, cfg.Events.Buffer)
+	app.events.Start(eventsCtx)
+
+	if err := app.initAuth(ctx); err != nil {
+		stop()
+		return nil, err
+	}
+
+	if err := validation.Register(); err != nil {
+		stop()
 		return nil, err
 	}
 
-	app.setupRoutes()
+	if err := app.setupRoutes(ctx); err != nil {
+		stop()
+		return nil, err
+	}
 	return app, nil
 }
 
@@ -69,6 +124,8 @@ func (a *App) Run() {
 		Addr:    a.cfg.Addr,
 		Handler: a.router,
 	}
+	// Event streams never become idle, stopping the hub ends them.
+	srv.RegisterOnShutdown(a.stopEvents)
 
 	go func() {
 		a.log.Info("Starting HTTP server", slog.String("addr", a.cfg.Addr))
@@ -90,25 +147,47 @@ func (a *App) Run() {
 	if err := srv.Shutdown(ctx); err != nil {
 		a.log.Error("Server forced to shutdown", slog.String("error", err.Error()))
 	}
+	a.stop()
+	if err := a.shutdownTracing(ctx); err != nil {
+		a.log.Error("Failed to flush traces", slog.String("error", err.Error()))
+	}
 
 	a.log.Info("Server exited")
 }
 
+func (a *App) initAuth(ctx context.Context) error {
+	var keys jwt.KeyProvider
+	if jwks := a.cfg.JWT.JWKS; jwks.Source != "" {
+		provider := jwt.NewJWKSProvider(a.log, jwks.Source, nil, jwks.RefreshInterval, jwks.MinRefetchInterval)
+		provider.Start(ctx)
+		keys = provider
+	}
+
+	validator, err := jwt.NewValidator(a.cfg.JWT, a.ssoClient, keys)
+	if err != nil {
+		return err
+	}
+	a.jwt = validator
+	a.denylist = revocation.New(a.redis, a.cfg.JWT.MaxTokenTTL)
+	a.roles = middleware.NewRoleResolver(a.log, a.ssoClient, a.redis, a.cfg.JWT.RoleCacheTTL)
+	return nil
+}
+
 func setupLogger(env string) *slog.Logger {
 	var log *slog.Logger
 	switch env {
 	case envLocal:
-		log = slog.New(
+		log = slog.New(slogtrace.NewTraceHandler(
 			slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}),
-		)
+		))
 	case envDev:
-		log = slog.New(
+		log = slog.New(slogtrace.NewTraceHandler(
 			slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}),
-		)
+		))
 	case envProd:
-		log = slog.New(
+		log = slog.New(slogtrace.NewTraceHandler(
 			slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}),
-		)
+		))
 	}
 	return log
 }
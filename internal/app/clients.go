@@ -1,42 +1,107 @@
 package app
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Citadelas/api-gateway/internal/config"
+	"github.com/Citadelas/api-gateway/internal/handlers/task"
+	"github.com/Citadelas/api-gateway/internal/lib/breaker"
+	"github.com/Citadelas/api-gateway/internal/lib/requestid"
+	"github.com/Citadelas/api-gateway/internal/lib/taskevents"
+	"github.com/Citadelas/api-gateway/internal/lib/taskindex"
 	ssov1 "github.com/Citadelas/protos/golang/sso"
 	taskv1 "github.com/Citadelas/protos/golang/task"
 	grpc_retry "github.com/grpc-ecosystem/go-grpc-middleware/retry"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer/leastrequest"
+	"google.golang.org/grpc/balancer/roundrobin"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
-	"time"
+	_ "google.golang.org/grpc/health" // enables client side health checking
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
 )
 
 func (a *App) mustInitClients() error {
 	// Initialize SSO client
-	ssoConn := mustGenerateClient(a.cfg.Services.SSO.Endpoint, a.cfg.Services.SSO.Timeout)
+	a.ssoConn = mustGenerateClient("sso", a.cfg.Services.SSO)
 
-	a.ssoClient = ssov1.NewAuthClient(ssoConn)
+	a.ssoClient = ssov1.NewAuthClient(a.ssoConn)
 
 	// Initialize Task client
-	taskConn := mustGenerateClient(a.cfg.Services.Task.Endpoint, a.cfg.Services.Task.Timeout)
+	a.taskConn = mustGenerateClient("task", a.cfg.Services.Task)
 
-	a.taskClient = taskv1.NewTaskServiceClient(taskConn)
+	a.taskIndex = taskindex.New(a.redis)
+	publisher := taskevents.NewPublisher(a.redis, a.cfg.Events.StreamMaxLen)
+	encode := func(t *taskv1.Task) any { return task.FromProto(t) }
+	a.taskClient = taskindex.WrapClient(
+		taskevents.WrapClient(taskv1.NewTaskServiceClient(a.taskConn), publisher, encode, a.log),
+		a.taskIndex, a.log,
+	)
 
 	return nil
 }
 
-func mustGenerateClient(endpoint string, timeout time.Duration) *grpc.ClientConn {
-	var opts []grpc.DialOption
-	opts = append(opts, grpc.WithUnaryInterceptor(grpc_retry.UnaryClientInterceptor(
+func mustGenerateClient(name string, svc config.Service) *grpc.ClientConn {
+	interceptors := []grpc.UnaryClientInterceptor{requestid.UnaryClientInterceptor()}
+	// The breaker goes first so an open circuit is not retried.
+	if svc.Breaker.FailureRatio > 0 {
+		interceptors = append(interceptors, breaker.New(name, svc.Breaker).UnaryClientInterceptor())
+	}
+	interceptors = append(interceptors, grpc_retry.UnaryClientInterceptor(
 		grpc_retry.WithCodes(codes.Unavailable, codes.ResourceExhausted),
 		grpc_retry.WithMax(5),
 		grpc_retry.WithBackoff(grpc_retry.BackoffLinear(time.Second)),
-	)))
+	))
+
+	target, opts := resolveTarget(name, svc)
+	opts = append(opts, grpc.WithDefaultServiceConfig(serviceConfig(svc)))
+	// Propagates the W3C trace context into outgoing metadata.
+	opts = append(opts, grpc.WithStatsHandler(otelgrpc.NewClientHandler()))
+	opts = append(opts, grpc.WithChainUnaryInterceptor(interceptors...))
 	opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithConnectParams(grpc.ConnectParams{
-		MinConnectTimeout: timeout,
+		MinConnectTimeout: svc.Timeout,
 	}))
-	conn, err := grpc.NewClient(endpoint, opts...)
+	conn, err := grpc.NewClient(target, opts...)
 	if err != nil {
 		panic(err)
 	}
 	return conn
 }
+
+// resolveTarget returns the dial target of the service. A list of
+// endpoints is served by a static resolver, a single endpoint is resolved
+// through DNS so every address behind the name gets traffic.
+func resolveTarget(name string, svc config.Service) (string, []grpc.DialOption) {
+	if len(svc.Endpoints) == 0 {
+		if strings.Contains(svc.Endpoint, ":///") {
+			return svc.Endpoint, nil
+		}
+		return "dns:///" + svc.Endpoint, nil
+	}
+
+	r := manual.NewBuilderWithScheme("static-" + name)
+	addrs := make([]resolver.Address, len(svc.Endpoints))
+	for i, endpoint := range svc.Endpoints {
+		addrs[i] = resolver.Address{Addr: endpoint}
+	}
+	r.InitialState(resolver.State{Addresses: addrs})
+	return r.Scheme() + ":///" + name, []grpc.DialOption{grpc.WithResolvers(r)}
+}
+
+// serviceConfig selects the load balancing policy and, if enabled, the
+// grpc.health.v1 checks that eject unhealthy backends from the balancer.
+func serviceConfig(svc config.Service) string {
+	lb := fmt.Sprintf(`{%q:{}}`, roundrobin.Name)
+	if svc.LoadBalancing == "least_request" {
+		lb = fmt.Sprintf(`{%q:{"choiceCount":2}}`, leastrequest.Name)
+	}
+	cfg := `{"loadBalancingConfig":[` + lb + `]`
+	if svc.HealthCheck {
+		cfg += `,"healthCheckConfig":{"serviceName":""}`
+	}
+	return cfg + "}"
+}
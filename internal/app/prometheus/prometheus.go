@@ -17,4 +17,21 @@ var (
 		},
 		[]string{"method", "path"},
 	)
+	// CacheLookupsTotal counts response cache lookups per tier, the hit
+	// ratio of a tier is hit / (hit + miss + stale).
+	CacheLookupsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_cache_lookups_total",
+			Help: "Total number of response cache lookups by tier and result.",
+		},
+		[]string{"tier", "result"},
+	)
+	// CircuitBreakerState is 0 for closed, 1 for open and 2 for half-open.
+	CircuitBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "grpc_circuit_breaker_state",
+			Help: "State of the gRPC client circuit breaker: 0 closed, 1 open, 2 half-open.",
+		},
+		[]string{"service"},
+	)
 )
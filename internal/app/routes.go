@@ -1,26 +1,52 @@
 package app
 
 import (
+	"context"
+	"net/http"
+	"time"
+
 	prometheus2 "github.com/Citadelas/api-gateway/internal/app/prometheus"
+	"github.com/Citadelas/api-gateway/internal/handlers/health"
 	"github.com/Citadelas/api-gateway/internal/handlers/sso"
 	"github.com/Citadelas/api-gateway/internal/handlers/task"
+	"github.com/Citadelas/api-gateway/internal/helpers/grpc"
+	"github.com/Citadelas/api-gateway/internal/helpers/problem"
+	"github.com/Citadelas/api-gateway/internal/lib/lock"
+	"github.com/Citadelas/api-gateway/internal/lib/ticket"
 	"github.com/Citadelas/api-gateway/internal/middleware"
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
-func (a *App) setupRoutes() {
+func (a *App) setupRoutes(ctx context.Context) error {
 
-	prometheus.MustRegister(prometheus2.RequestsTotal, prometheus2.RequestDuration)
+	prometheus.MustRegister(prometheus2.RequestsTotal, prometheus2.RequestDuration, prometheus2.CacheLookupsTotal, prometheus2.CircuitBreakerState)
 
-	a.router = gin.Default()
+	a.router = gin.New()
+	// Let handlers pass *gin.Context to gRPC clients and loggers with the
+	// request context values (trace span) and cancellation.
+	a.router.ContextWithFallback = true
+	// The client IP of rate limits comes from X-Forwarded-For only when
+	// set by a known proxy, otherwise clients could pick their own.
+	if err := a.router.SetTrustedProxies(a.cfg.TrustedProxies); err != nil {
+		return err
+	}
 
 	// Add middleware
-	a.router.Use(gin.Recovery())
-	a.router.Use(gin.Logger())
+	a.router.HandleMethodNotAllowed = true
+	a.router.NoRoute(problem.NoRoute)
+	a.router.NoMethod(problem.NoMethod)
+	a.router.Use(gin.CustomRecovery(problem.Recovery))
+	a.router.Use(grpc.WithOptions(grpc.Options{HideInternal: a.cfg.Env == envProd}))
+	a.router.Use(middleware.RequestIDMiddleware())
+	a.router.Use(gin.LoggerWithFormatter(middleware.AccessLogFormatter))
+
+	a.router.Use(otelgin.Middleware(a.cfg.Tracing.ServiceName, otelgin.WithFilter(notProbe)))
 
 	a.router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	a.setupHealthRoutes()
 	a.router.Use(middleware.PrometheusMiddleware())
 
 	api := a.router.Group("/api/v1")
@@ -29,32 +55,104 @@ func (a *App) setupRoutes() {
 	a.setupAuthRoutes(api)
 
 	// Protected routes
-	a.setupProtectedRoutes(api)
+	a.setupProtectedRoutes(ctx, api)
+
+	// Task event streams
+	a.setupStreamRoutes(api)
+
+	// Admin only routes
+	a.setupAdminRoutes(api)
+	return nil
+}
+
+// notProbe keeps metrics scrapes and health probes out of traces
+func notProbe(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/metrics", "/livez", "/readyz":
+		return false
+	}
+	return true
+}
+
+// setupHealthRoutes configures liveness and readiness probes
+func (a *App) setupHealthRoutes() {
+	deps := []health.Dependency{
+		{Name: "sso", Required: true, Check: health.GRPCCheck(a.ssoConn)},
+		{Name: "task", Required: true, Check: health.GRPCCheck(a.taskConn)},
+		{Name: "redis", Required: true, Check: health.RedisCheck(a.redis)},
+	}
+	a.router.GET("/livez", health.LivenessHandler())
+	a.router.GET("/readyz", health.ReadinessHandler(a.log, deps, 2*time.Second))
 }
 
 // setupAuthRoutes configures authentication routes
 func (a *App) setupAuthRoutes(api *gin.RouterGroup) {
 	auth := api.Group("/auth")
+	auth.Use(middleware.RateLimitMiddleware(a.log, a.redis, "auth", a.cfg.RateLimits["auth"]))
 	{
 		auth.POST("/login", sso.LoginHandler(a.log, a.ssoClient))
 		auth.POST("/register", sso.RegisterHandler(a.log, a.ssoClient))
 		auth.POST("/refresh", sso.RefreshToken(a.log, a.ssoClient))
-		auth.POST("/isadmin", sso.IsAdmin(a.log, a.ssoClient))
+		auth.GET("/isadmin", middleware.AuthMiddleware(a.jwt, a.denylist), sso.IsAdminSelf(a.log, a.ssoClient))
+		auth.POST("/logout", middleware.AuthMiddleware(a.jwt, a.denylist), sso.LogoutHandler(a.log, a.denylist))
 	}
 }
 
 // setupProtectedRoutes configures protected routes
-func (a *App) setupProtectedRoutes(api *gin.RouterGroup) {
+func (a *App) setupProtectedRoutes(ctx context.Context, api *gin.RouterGroup) {
 	protected := api.Group("/")
-	protected.Use(middleware.AuthMiddleware(a.ssoClient))
-	protected.Use(middleware.CacheMiddleware(a.log, a.redis))
+	protected.Use(middleware.AuthMiddleware(a.jwt, a.denylist))
+	protected.Use(middleware.RateLimitMiddleware(a.log, a.redis, "protected", a.cfg.RateLimits["protected"]))
+	protected.Use(middleware.IdempotencyMiddleware(a.log, a.redis, a.cfg.Idempotency))
+	protected.Use(middleware.CacheMiddleware(ctx, a.log, a.redis, a.cfg.Cache, task.CacheTags))
+	locks := lock.New(a.redis)
 	// Task routes
 	tasks := protected.Group("/tasks")
 	{
+		tasks.GET("", task.ListTasksHandler(a.log, a.taskClient, a.taskIndex))
 		tasks.POST("", task.CreateTaskHandler(a.log, a.taskClient))
 		tasks.GET("/:id", task.GetTaskHandler(a.log, a.taskClient))
-		tasks.PUT("/:id", task.UpdateTaskHandler(a.log, a.taskClient))
-		tasks.DELETE("/:id", task.DeleteTaskHandler(a.log, a.taskClient))
-		tasks.PATCH("/:id/status", task.UpdateStatusHandler(a.log, a.taskClient))
+		tasks.PUT("/:id", task.UpdateTaskHandler(a.log, a.taskClient, locks))
+		tasks.PATCH("/:id", task.PatchTaskHandler(a.log, a.taskClient, locks))
+		tasks.DELETE("/:id", task.DeleteTaskHandler(a.log, a.taskClient, locks))
+		tasks.PATCH("/:id/status", task.UpdateStatusHandler(a.log, a.taskClient, locks))
+	}
+
+	// Gin cannot route a literal colon inside a path segment, so custom
+	// methods like POST /tasks:batch share one parameter route.
+	batch := task.BatchHandler(a.log, a.taskClient, locks)
+	protected.POST("/:method", func(c *gin.Context) {
+		switch c.Param("method") {
+		case "tasks:batch":
+			batch(c)
+		default:
+			problem.NoRoute(c)
+		}
+	})
+}
+
+// setupStreamRoutes configures the task event streams. Browser EventSource
+// and WebSocket cannot send the Authorization header, so the streams also
+// accept a single-use ticket issued to an authenticated client.
+func (a *App) setupStreamRoutes(api *gin.RouterGroup) {
+	tickets := ticket.New(a.redis, a.cfg.Events.TicketTTL)
+	auth := middleware.AuthMiddleware(a.jwt, a.denylist)
+	streamAuth := middleware.TicketAuthMiddleware(a.jwt, a.denylist, tickets)
+	limit := middleware.RateLimitMiddleware(a.log, a.redis, "protected", a.cfg.RateLimits["protected"])
+	streams := api.Group("/tasks")
+	{
+		streams.POST("/stream/ticket", auth, limit, task.StreamTicketHandler(a.log, tickets))
+		streams.GET("/stream", streamAuth, limit, task.StreamTasksHandler(a.log, a.events, a.denylist, a.cfg.Events.Heartbeat))
+		streams.GET("/ws", streamAuth, limit, task.TaskSocketHandler(a.log, a.events, a.denylist, a.cfg.Events.Heartbeat))
+	}
+}
+
+// setupAdminRoutes configures routes available to admins only
+func (a *App) setupAdminRoutes(api *gin.RouterGroup) {
+	admin := api.Group("/admin")
+	admin.Use(middleware.AuthMiddleware(a.jwt, a.denylist))
+	admin.Use(middleware.RequireAdmin(a.roles))
+	{
+		admin.POST("/isadmin", sso.IsAdmin(a.log, a.ssoClient))
 	}
 }
@@ -0,0 +1,70 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/Citadelas/api-gateway/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+const (
+	exporterNone   = "none"
+	exporterStdout = "stdout"
+	exporterOTLP   = "otlp"
+)
+
+// setupTracing installs the global tracer provider and the W3C trace
+// context propagator. The returned function flushes and stops the provider.
+func setupTracing(ctx context.Context, cfg config.Tracing) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	exporter, err := newTraceExporter(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if exporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	tp := newTracerProvider(cfg, exporter)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// newTracerProvider batches spans to exporter. Tests can pass an in-memory
+// exporter from go.opentelemetry.io/otel/sdk/trace/tracetest.
+func newTracerProvider(cfg config.Tracing, exporter sdktrace.SpanExporter) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(cfg.ServiceName))),
+	)
+}
+
+func newTraceExporter(ctx context.Context, cfg config.Tracing) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case exporterNone, "":
+		return nil, nil
+	case exporterStdout:
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case exporterOTLP:
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+	}
+}
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Citadelas/api-gateway/internal/config"
+	taskv1 "github.com/Citadelas/protos/golang/task"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// traceServer is a task service that records the trace context it got.
+type traceServer struct {
+	taskv1.UnimplementedTaskServiceServer
+	traceparent chan string
+}
+
+func (s *traceServer) GetTask(ctx context.Context, in *taskv1.GetTaskRequest) (*taskv1.GetTaskResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	tp := ""
+	if v := md.Get("traceparent"); len(v) > 0 {
+		tp = v[0]
+	}
+	s.traceparent <- tp
+	return &taskv1.GetTaskResponse{Task: &taskv1.Task{Id: in.GetId()}}, nil
+}
+
+// TestTracingPropagation checks that an HTTP request produces a server span
+// with a gRPC client span as its child in the same trace, and that the
+// trace context reaches the backend.
+func TestTracingPropagation(t *testing.T) {
+	cfg := config.Tracing{Exporter: exporterNone, ServiceName: "api-gateway-test", SampleRatio: 1}
+	if _, err := setupTracing(context.Background(), cfg); err != nil {
+		t.Fatalf("setupTracing: %v", err)
+	}
+	exporter := tracetest.NewInMemoryExporter()
+	tp := newTracerProvider(cfg, exporter)
+	t.Cleanup(func() { _ = tp.Shutdown(context.Background()) })
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &traceServer{traceparent: make(chan string, 1)}
+	srv := grpc.NewServer()
+	taskv1.RegisterTaskServiceServer(srv, backend)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient(lis.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	client := taskv1.NewTaskServiceClient(conn)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.ContextWithFallback = true
+	router.Use(otelgin.Middleware(cfg.ServiceName))
+	router.GET("/tasks/:id", func(c *gin.Context) {
+		if _, err := client.GetTask(c, &taskv1.GetTaskRequest{Id: 1}); err != nil {
+			c.Status(500)
+			return
+		}
+		c.Status(200)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/tasks/1", nil))
+	if w.Code != 200 {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if err := tp.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var httpSpan, grpcSpan *tracetest.SpanStub
+	spans := exporter.GetSpans()
+	for i := range spans {
+		switch spans[i].SpanKind {
+		case trace.SpanKindServer:
+			if httpSpan != nil {
+				t.Fatal("more than one HTTP server span")
+			}
+			httpSpan = &spans[i]
+		case trace.SpanKindClient:
+			grpcSpan = &spans[i]
+		}
+	}
+	if httpSpan == nil || grpcSpan == nil {
+		t.Fatalf("got %d spans, want an HTTP server span and a gRPC client span", len(spans))
+	}
+	if grpcSpan.SpanContext.TraceID() != httpSpan.SpanContext.TraceID() {
+		t.Fatal("gRPC span is in another trace than the HTTP span")
+	}
+	if grpcSpan.Parent.SpanID() != httpSpan.SpanContext.SpanID() {
+		t.Fatal("gRPC span is not a child of the HTTP span")
+	}
+
+	sent := propagation.TraceContext{}.Extract(context.Background(), propagation.MapCarrier{"traceparent": <-backend.traceparent})
+	if got := trace.SpanContextFromContext(sent).TraceID(); got != httpSpan.SpanContext.TraceID() {
+		t.Fatalf("backend got trace %s, want %s", got, httpSpan.SpanContext.TraceID())
+	}
+}
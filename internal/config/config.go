@@ -2,6 +2,7 @@ package config
 
 import (
 	"flag"
+	"log/slog"
 	"os"
 	"time"
 
@@ -9,10 +10,44 @@ import (
 )
 
 type Config struct {
-	Addr     string   `yaml:"addr"`
-	Env      string   `yaml:"env" env-default:"local"`
-	Services Services `yaml:"services"`
-	Redis    Redis    `yaml:"redis"`
+	Addr string `yaml:"addr"`
+	Env  string `yaml:"env" env-default:"local"`
+	// TrustedProxies are the addresses or CIDRs of reverse proxies whose
+	// X-Forwarded-For is used for the client IP. Empty trusts none.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	Services       Services `yaml:"services"`
+	Redis          Redis    `yaml:"redis"`
+	JWT            JWT      `yaml:"jwt"`
+	Cache          Cache    `yaml:"cache"`
+	Tracing        Tracing  `yaml:"tracing"`
+	// Idempotency configures Idempotency-Key handling of mutations.
+	Idempotency Idempotency `yaml:"idempotency"`
+	// Events configures the real-time task event streams.
+	Events Events `yaml:"events"`
+	// RateLimits holds per route group limits keyed by group name
+	// ("auth", "protected"). Groups without an entry are not limited.
+	RateLimits map[string]RateLimit `yaml:"rate_limits"`
+}
+
+// redacted replaces secret values in logs.
+const redacted = "[REDACTED]"
+
+// LogValue logs the config without secrets. Handlers do not resolve
+// LogValue of nested fields, so the secrets of every section are masked
+// here.
+func (c Config) LogValue() slog.Value {
+	type plain Config
+	p := plain(c)
+	p.JWT = c.JWT.redact()
+	if p.Redis.Password != "" {
+		p.Redis.Password = redacted
+	}
+	return slog.AnyValue(p)
+}
+
+type RateLimit struct {
+	Limit  int           `yaml:"limit"`
+	Window time.Duration `yaml:"window"`
 }
 
 type Redis struct {
@@ -21,14 +56,145 @@ type Redis struct {
 	Password string `yaml:"password"`
 }
 
+type Tracing struct {
+	// Exporter is "otlp", "stdout" or "none".
+	Exporter    string `yaml:"exporter" env-default:"none"`
+	ServiceName string `yaml:"service_name" env-default:"api-gateway"`
+	// Endpoint is the OTLP gRPC collector address, e.g. "otel-collector:4317".
+	Endpoint    string  `yaml:"endpoint"`
+	Insecure    bool    `yaml:"insecure"`
+	SampleRatio float64 `yaml:"sample_ratio" env-default:"1"`
+}
+
+type Idempotency struct {
+	// TTL is how long a response is kept for replays of its key.
+	TTL time.Duration `yaml:"ttl" env-default:"24h"`
+	// LockTTL bounds how long an in-flight request holds its key.
+	LockTTL time.Duration `yaml:"lock_ttl" env-default:"1m"`
+	// MaxBodySize bounds the request body buffered for the fingerprint.
+	MaxBodySize int64 `yaml:"max_body_size" env-default:"1048576"`
+}
+
+type Events struct {
+	// StreamMaxLen is roughly how many recent events of a user are kept
+	// for clients resuming with Last-Event-ID.
+	StreamMaxLen int64 `yaml:"stream_max_len" env-default:"1000"`
+	// Buffer is how many undelivered events a connection may hold before
+	// it is closed as too slow.
+	Buffer int `yaml:"buffer" env-default:"64"`
+	// Heartbeat is the interval of keep-alive messages on idle streams.
+	Heartbeat time.Duration `yaml:"heartbeat" env-default:"15s"`
+	// TicketTTL is how long a stream ticket can be redeemed.
+	TicketTTL time.Duration `yaml:"ticket_ttl" env-default:"30s"`
+}
+
+type Cache struct {
+	Policies []CachePolicy `yaml:"policies"`
+	Local    LocalCache    `yaml:"local"`
+}
+
+// LocalCache is the optional in-process LRU tier in front of Redis.
+type LocalCache struct {
+	Enabled bool          `yaml:"enabled"`
+	Size    int           `yaml:"size" env-default:"10000"`
+	TTL     time.Duration `yaml:"ttl" env-default:"10s"`
+}
+
+// CachePolicy enables response caching for GET requests of a route.
+type CachePolicy struct {
+	// Route is a gin route pattern, e.g. "/api/v1/tasks/:id".
+	Route string        `yaml:"route"`
+	TTL   time.Duration `yaml:"ttl"`
+	// VaryByUser keeps a separate entry per authenticated user.
+	VaryByUser bool `yaml:"vary_by_user"`
+	// VaryHeaders are request headers whose values are part of the key.
+	VaryHeaders []string `yaml:"vary_headers"`
+	// StaleWhileRevalidate serves an expired entry for this long while a
+	// single request refreshes it.
+	StaleWhileRevalidate time.Duration `yaml:"stale_while_revalidate"`
+	// StaleIfError serves an expired entry for this long while the backend
+	// answers with 5xx, e.g. Unavailable.
+	StaleIfError time.Duration `yaml:"stale_if_error"`
+}
+
+type JWT struct {
+	Issuer   string        `yaml:"issuer"`
+	Audience string        `yaml:"audience"`
+	Leeway   time.Duration `yaml:"leeway" env-default:"30s"`
+	// AppIDs lists applications whose tokens are accepted. Empty accepts any app.
+	AppIDs []int32 `yaml:"app_ids"`
+	// Secrets maps app_id to the HS256 secret the SSO signs its tokens with.
+	Secrets map[int32]string `yaml:"secrets"`
+	// PublicKeyPath is a PEM encoded RSA or ECDSA key for RS256/ES256 tokens.
+	PublicKeyPath string `yaml:"public_key_path"`
+	JWKS          JWKS   `yaml:"jwks"`
+	// MaxTokenTTL is the longest access token lifetime. "Log out everywhere"
+	// cutoffs are kept this long.
+	MaxTokenTTL time.Duration `yaml:"max_token_ttl" env-default:"24h"`
+	// RoleCacheTTL is how long SSO IsAdmin answers are cached.
+	RoleCacheTTL time.Duration `yaml:"role_cache_ttl" env-default:"5m"`
+	// SSOUserCheck additionally asks SSO whether the token user still exists.
+	SSOUserCheck bool `yaml:"sso_user_check"`
+}
+
+// LogValue logs the JWT config with masked secrets.
+func (j JWT) LogValue() slog.Value {
+	type plain JWT
+	return slog.AnyValue(plain(j.redact()))
+}
+
+func (j JWT) redact() JWT {
+	if len(j.Secrets) == 0 {
+		return j
+	}
+	secrets := make(map[int32]string, len(j.Secrets))
+	for appID := range j.Secrets {
+		secrets[appID] = redacted
+	}
+	j.Secrets = secrets
+	return j
+}
+
+// JWKS configures the RS256/ES256 key set the SSO publishes.
+type JWKS struct {
+	// Source is an http(s) URL or a file path. Empty disables JWKS.
+	Source          string        `yaml:"source"`
+	RefreshInterval time.Duration `yaml:"refresh_interval" env-default:"5m"`
+	// MinRefetchInterval limits refetches triggered by unknown key ids.
+	MinRefetchInterval time.Duration `yaml:"min_refetch_interval" env-default:"30s"`
+}
+
 type Services struct {
 	Task Service `yaml:"task"`
 	SSO  Service `yaml:"sso"`
 }
 
 type Service struct {
-	Endpoint string        `yaml:"endpoint"`
-	Timeout  time.Duration `yaml:"timeout"`
+	Endpoint string `yaml:"endpoint"`
+	// Endpoints lists backend replicas and takes precedence over Endpoint.
+	// A DNS name in Endpoint that resolves to several addresses is balanced
+	// as well.
+	Endpoints []string `yaml:"endpoints"`
+	// LoadBalancing is "round_robin" or "least_request".
+	LoadBalancing string `yaml:"load_balancing" env-default:"round_robin"`
+	// HealthCheck ejects backends whose grpc.health.v1 status is not SERVING.
+	HealthCheck bool          `yaml:"health_check"`
+	Timeout     time.Duration `yaml:"timeout"`
+	Breaker     Breaker       `yaml:"breaker"`
+}
+
+// Breaker configures the circuit breaker of a backend. A zero
+// FailureRatio disables it.
+type Breaker struct {
+	// FailureRatio of failed calls in a window that opens the circuit.
+	FailureRatio float64 `yaml:"failure_ratio"`
+	// MinRequests in a window before the ratio is considered.
+	MinRequests int           `yaml:"min_requests" env-default:"10"`
+	Window      time.Duration `yaml:"window" env-default:"30s"`
+	// CoolDown is how long the circuit stays open before probing.
+	CoolDown time.Duration `yaml:"cool_down" env-default:"15s"`
+	// HalfOpenRequests probe calls must succeed to close the circuit.
+	HalfOpenRequests int `yaml:"half_open_requests" env-default:"1"`
 }
 
 type GRPCConfig struct {
@@ -0,0 +1,124 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Citadelas/api-gateway/internal/lib/requestid"
+	"github.com/gin-gonic/gin"
+	grpc_retry "github.com/grpc-ecosystem/go-grpc-middleware/retry"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	statusUp   = "up"
+	statusDown = "down"
+)
+
+// Check probes a dependency and returns its connection state, if any.
+type Check func(ctx context.Context) (state string, err error)
+
+type Dependency struct {
+	Name string
+	// Required dependencies make the gateway not ready when down.
+	Required bool
+	Check    Check
+}
+
+type checkResult struct {
+	Status   string `json:"status"`
+	State    string `json:"state,omitempty"`
+	Required bool   `json:"required"`
+	Error    string `json:"error,omitempty"`
+}
+
+// LivenessHandler reports that the process is up and serving HTTP.
+func LivenessHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, gin.H{"status": "ok"})
+	}
+}
+
+// ReadinessHandler probes all dependencies concurrently and answers 503
+// when any required one is down.
+func ReadinessHandler(log *slog.Logger, deps []Dependency, timeout time.Duration) gin.HandlerFunc {
+	const op = "handlers.health.Readiness"
+	log = log.With("op", op)
+	return func(c *gin.Context) {
+		log := log.With(slog.String("request_id", requestid.FromContext(c)))
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		results := make(map[string]checkResult, len(deps))
+		var (
+			mu sync.Mutex
+			wg sync.WaitGroup
+		)
+		for _, dep := range deps {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				state, err := dep.Check(ctx)
+				res := checkResult{Status: statusUp, State: state, Required: dep.Required}
+				if err != nil {
+					res.Status, res.Error = statusDown, err.Error()
+				}
+				mu.Lock()
+				results[dep.Name] = res
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+
+		ready := true
+		for name, res := range results {
+			if res.Status == statusDown {
+				log.WarnContext(c, "Dependency is down", slog.String("dependency", name), slog.String("error", res.Error))
+				if res.Required {
+					ready = false
+				}
+			}
+		}
+		if !ready {
+			c.JSON(503, gin.H{"status": "unavailable", "checks": results})
+			return
+		}
+		c.JSON(200, gin.H{"status": "ok", "checks": results})
+	}
+}
+
+// GRPCCheck calls the standard grpc.health.v1 service of the backend. A
+// backend without the health service counts as up once it answers.
+func GRPCCheck(conn *grpc.ClientConn) Check {
+	client := healthpb.NewHealthClient(conn)
+	return func(ctx context.Context) (string, error) {
+		if conn.GetState() == connectivity.Idle {
+			conn.Connect()
+		}
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc_retry.Disable())
+		state := conn.GetState().String()
+		switch {
+		case status.Code(err) == codes.Unimplemented:
+			return state, nil
+		case err != nil:
+			return state, err
+		case resp.GetStatus() != healthpb.HealthCheckResponse_SERVING:
+			return state, fmt.Errorf("health status %s", resp.GetStatus())
+		}
+		return state, nil
+	}
+}
+
+func RedisCheck(client *redis.Client) Check {
+	return func(ctx context.Context) (string, error) {
+		return "", client.Ping(ctx).Err()
+	}
+}
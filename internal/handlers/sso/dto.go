@@ -0,0 +1,39 @@
+package sso
+
+import ssov1 "github.com/Citadelas/protos/golang/sso"
+
+type LoginResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func loginResponseFromProto(resp *ssov1.LoginResponse) LoginResponse {
+	return LoginResponse{
+		Token:        resp.GetToken(),
+		RefreshToken: resp.GetRefreshToken(),
+	}
+}
+
+type RegisterResponse struct {
+	UserID int64 `json:"user_id"`
+}
+
+func registerResponseFromProto(resp *ssov1.RegisterResponse) RegisterResponse {
+	return RegisterResponse{UserID: resp.GetUserId()}
+}
+
+type RefreshResponse struct {
+	AccessToken string `json:"access_token"`
+}
+
+func refreshResponseFromProto(resp *ssov1.RefreshTokenResponse) RefreshResponse {
+	return RefreshResponse{AccessToken: resp.GetAccessToken()}
+}
+
+type IsAdminResponse struct {
+	IsAdmin bool `json:"is_admin"`
+}
+
+func isAdminResponseFromProto(resp *ssov1.IsAdminResponse) IsAdminResponse {
+	return IsAdminResponse{IsAdmin: resp.GetIsAdmin()}
+}
@@ -1,27 +1,44 @@
 package sso
 
 import (
+	"errors"
+	"io"
 	"log/slog"
+	"time"
 
 	"github.com/Citadelas/api-gateway/internal/helpers/grpc"
+	"github.com/Citadelas/api-gateway/internal/helpers/problem"
+	"github.com/Citadelas/api-gateway/internal/helpers/validation"
+	"github.com/Citadelas/api-gateway/internal/lib/jwt"
 	"github.com/Citadelas/api-gateway/internal/lib/logger/sl"
+	"github.com/Citadelas/api-gateway/internal/lib/requestid"
+	"github.com/Citadelas/api-gateway/internal/lib/revocation"
 	ssov1 "github.com/Citadelas/protos/golang/sso"
 	"github.com/gin-gonic/gin"
 )
 
 type Req struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required,max=72"`
+}
+
+// RegisterReq enforces the password policy, Req does not so users with
+// older passwords can still log in.
+type RegisterReq struct {
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required,min=8,max=72"`
 }
 
 func LoginHandler(log *slog.Logger, client ssov1.AuthClient) gin.HandlerFunc {
 	const op = "handlers.sso.Login"
 	log = log.With("op", op)
 	return func(c *gin.Context) {
+		log := log.With(slog.String("request_id", requestid.FromContext(c)))
 		var req Req
-		if err := c.ShouldBind(&req); err != nil {
-			log.Error("Error json bind", sl.Err(err))
-			c.JSON(400, gin.H{"error": "invalid request"})
+		if err := c.ShouldBindJSON(&req); err != nil {
+			log.InfoContext(c, "Invalid request", sl.Err(err))
+			problem.Write(c, validation.Problem(err))
+			return
 		}
 		grpcReq := ssov1.LoginRequest{
 			AppId:    1,
@@ -30,12 +47,12 @@ func LoginHandler(log *slog.Logger, client ssov1.AuthClient) gin.HandlerFunc {
 		}
 		resp, err := client.Login(c, &grpcReq)
 		if err != nil {
-			log.Error("Error making grpc login request", sl.Err(err))
+			log.ErrorContext(c, "Error making grpc login request", sl.Err(err))
 			grpc.HandleGRPCError(c, err)
 			return
 		}
-		log.Info("User login successfully")
-		c.JSON(200, resp)
+		log.InfoContext(c, "User login successfully")
+		c.JSON(200, loginResponseFromProto(resp))
 	}
 }
 
@@ -43,10 +60,11 @@ func RegisterHandler(log *slog.Logger, client ssov1.AuthClient) gin.HandlerFunc
 	const op = "handlers.sso.Register"
 	log = log.With("op", op)
 	return func(c *gin.Context) {
-		var req Req
-		if err := c.ShouldBind(&req); err != nil {
-			log.Error("Error json bind", sl.Err(err))
-			c.JSON(400, gin.H{"error": "invalid request"})
+		log := log.With(slog.String("request_id", requestid.FromContext(c)))
+		var req RegisterReq
+		if err := c.ShouldBindJSON(&req); err != nil {
+			log.InfoContext(c, "Invalid request", sl.Err(err))
+			problem.Write(c, validation.Problem(err))
 			return
 		}
 		grpcReq := ssov1.RegisterRequest{
@@ -55,27 +73,29 @@ func RegisterHandler(log *slog.Logger, client ssov1.AuthClient) gin.HandlerFunc
 		}
 		resp, err := client.Register(c, &grpcReq)
 		if err != nil {
-			log.Error("Error making grpc register request", sl.Err(err))
+			log.ErrorContext(c, "Error making grpc register request", sl.Err(err))
 			grpc.HandleGRPCError(c, err)
 			return
 		}
-		log.Info("User registered successfully")
-		c.JSON(200, resp)
+		log.InfoContext(c, "User registered successfully")
+		c.JSON(200, registerResponseFromProto(resp))
 	}
 }
 
 type refReq struct {
-	RefreshToken string `json:"refresh_token"`
+	RefreshToken string `json:"refresh_token" binding:"required"`
 }
 
 func RefreshToken(log *slog.Logger, client ssov1.AuthClient) gin.HandlerFunc {
 	const op = "handlers.sso.Refresh"
 	log = log.With("op", op)
 	return func(c *gin.Context) {
+		log := log.With(slog.String("request_id", requestid.FromContext(c)))
 		var req refReq
-		if err := c.ShouldBind(&req); err != nil {
-			log.Error("Error json bind", sl.Err(err))
-			c.JSON(400, gin.H{"error": "invalid request"})
+		if err := c.ShouldBindJSON(&req); err != nil {
+			log.InfoContext(c, "Invalid request", sl.Err(err))
+			problem.Write(c, validation.Problem(err))
+			return
 		}
 		grpcReq := ssov1.RefreshTokenRequest{
 			AppId:        1,
@@ -83,37 +103,94 @@ func RefreshToken(log *slog.Logger, client ssov1.AuthClient) gin.HandlerFunc {
 		}
 		resp, err := client.RefreshToken(c, &grpcReq)
 		if err != nil {
-			log.Error("Error making grpc refresh token request", sl.Err(err))
+			log.ErrorContext(c, "Error making grpc refresh token request", sl.Err(err))
 			grpc.HandleGRPCError(c, err)
 			return
 		}
-		log.Info("User refreshed token successfully")
-		c.JSON(200, resp)
+		log.InfoContext(c, "User refreshed token successfully")
+		c.JSON(200, refreshResponseFromProto(resp))
 	}
 }
 
 type adminReq struct {
-	UserId int64 `json:"user_id"`
+	UserId int64 `json:"user_id" binding:"required,gt=0"`
 }
 
 func IsAdmin(log *slog.Logger, client ssov1.AuthClient) gin.HandlerFunc {
 	const op = "handlers.sso.IsAdmin"
 	log = log.With("op", op)
 	return func(c *gin.Context) {
+		log := log.With(slog.String("request_id", requestid.FromContext(c)))
 		var req adminReq
-		if err := c.ShouldBind(&req); err != nil {
-			log.Error("Error json bind", sl.Err(err))
-			c.JSON(400, gin.H{"error": "invalid request"})
+		if err := c.ShouldBindJSON(&req); err != nil {
+			log.InfoContext(c, "Invalid request", sl.Err(err))
+			problem.Write(c, validation.Problem(err))
+			return
 		}
 		grpcReq := ssov1.IsAdminRequest{
 			UserId: req.UserId,
 		}
 		resp, err := client.IsAdmin(c, &grpcReq)
 		if err != nil {
-			log.Error("Error making grpc is admin request", sl.Err(err))
+			log.ErrorContext(c, "Error making grpc is admin request", sl.Err(err))
+			grpc.HandleGRPCError(c, err)
+			return
+		}
+		c.JSON(200, isAdminResponseFromProto(resp))
+	}
+}
+
+// IsAdminSelf reports whether the caller is an admin.
+func IsAdminSelf(log *slog.Logger, client ssov1.AuthClient) gin.HandlerFunc {
+	const op = "handlers.sso.IsAdminSelf"
+	log = log.With("op", op)
+	return func(c *gin.Context) {
+		log := log.With(slog.String("request_id", requestid.FromContext(c)))
+		uid := c.GetUint64("userID")
+		grpcReq := ssov1.IsAdminRequest{
+			UserId: int64(uid),
+		}
+		resp, err := client.IsAdmin(c, &grpcReq)
+		if err != nil {
+			log.ErrorContext(c, "Error making grpc is admin request", sl.Err(err))
 			grpc.HandleGRPCError(c, err)
 			return
 		}
-		c.JSON(200, resp.GetIsAdmin())
+		c.JSON(200, isAdminResponseFromProto(resp))
+	}
+}
+
+type logoutReq struct {
+	// All revokes every token of the user, not only the current one.
+	All bool `json:"all"`
+}
+
+func LogoutHandler(log *slog.Logger, denylist *revocation.Store) gin.HandlerFunc {
+	const op = "handlers.sso.Logout"
+	log = log.With("op", op)
+	return func(c *gin.Context) {
+		log := log.With(slog.String("request_id", requestid.FromContext(c)))
+		var req logoutReq
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			log.InfoContext(c, "Invalid request", sl.Err(err))
+			problem.Write(c, validation.Problem(err))
+			return
+		}
+		claims := c.MustGet("claims").(*jwt.CustomClaims)
+		token := jwt.ExtractToken(c.GetHeader("Authorization"))
+
+		var err error
+		if req.All {
+			err = denylist.RevokeUser(c.Request.Context(), claims.UserID, time.Now())
+		} else {
+			err = denylist.RevokeToken(c.Request.Context(), token, claims)
+		}
+		if err != nil {
+			log.ErrorContext(c, "Error revoking token", sl.Err(err))
+			problem.Abort(c, 503, "failed to revoke token")
+			return
+		}
+		log.InfoContext(c, "User logged out successfully", slog.Bool("all", req.All))
+		c.Status(204)
 	}
 }
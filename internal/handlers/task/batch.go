@@ -0,0 +1,438 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/Citadelas/api-gateway/internal/helpers/grpc"
+	"github.com/Citadelas/api-gateway/internal/helpers/problem"
+	"github.com/Citadelas/api-gateway/internal/helpers/validation"
+	"github.com/Citadelas/api-gateway/internal/lib/lock"
+	"github.com/Citadelas/api-gateway/internal/lib/logger/sl"
+	"github.com/Citadelas/api-gateway/internal/lib/requestid"
+	"github.com/Citadelas/api-gateway/internal/lib/taskenum"
+	"github.com/Citadelas/api-gateway/internal/middleware"
+	taskv1 "github.com/Citadelas/protos/golang/task"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// batchConcurrency bounds parallel task service calls of one batch.
+const batchConcurrency = 8
+
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpStatus = "status"
+	OpDelete = "delete"
+)
+
+type BatchOperation struct {
+	Op string `json:"op" binding:"required,oneof=create update status delete"`
+	// ID is the task of update, status and delete operations.
+	ID uint64 `json:"id"`
+	// Task is the new task of create and update operations.
+	Task   *Task  `json:"task"`
+	Status string `json:"status" binding:"omitempty,task_status"`
+	// IfMatch is the If-Match precondition of an operation on an existing
+	// task, checked like the header of the single task endpoints.
+	IfMatch string `json:"if_match"`
+}
+
+type BatchRequest struct {
+	// Atomic undoes the operations that succeeded when any operation fails.
+	Atomic     bool             `json:"atomic"`
+	Operations []BatchOperation `json:"operations" binding:"required,min=1,max=100,dive"`
+}
+
+// BatchResult is the outcome of one operation with the HTTP status the
+// single task endpoint would have returned.
+type BatchResult struct {
+	Status int              `json:"status"`
+	Task   *Task            `json:"task,omitempty"`
+	Error  *problem.Problem `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+	// RolledBack is set when an atomic batch failed and every operation
+	// that had succeeded was undone.
+	RolledBack bool `json:"rolled_back,omitempty"`
+}
+
+// check reports the fields the operation needs but does not have.
+func (op BatchOperation) check(i int) []problem.FieldError {
+	prefix := "operations[" + strconv.Itoa(i) + "]."
+	var errs []problem.FieldError
+	if op.Op != OpCreate && op.ID == 0 {
+		errs = append(errs, problem.FieldError{Field: prefix + "id", Message: "is required"})
+	}
+	if (op.Op == OpCreate || op.Op == OpUpdate) && op.Task == nil {
+		errs = append(errs, problem.FieldError{Field: prefix + "task", Message: "is required"})
+	}
+	if op.Op == OpStatus && op.Status == "" {
+		errs = append(errs, problem.FieldError{Field: prefix + "status", Message: "is required"})
+	}
+	return errs
+}
+
+// BatchHandler runs a list of task operations with bounded concurrency and
+// returns a result per operation. Operations on the same task run one after
+// another in request order, each under the task lock of the single task
+// endpoints. In atomic mode a task may appear only once, deletes run after
+// every other operation succeeded, and on failure the succeeded operations
+// are compensated: creates are deleted, updates and status changes restored
+// from a snapshot, and deleted tasks recreated (with a new ID). Atomic
+// batches hold their task locks until the rollback is done.
+func BatchHandler(log *slog.Logger, client taskv1.TaskServiceClient, locks *lock.Locker) gin.HandlerFunc {
+	const op = "handlers.task.Batch"
+	log = log.With("op", op)
+	return func(c *gin.Context) {
+		log := log.With(slog.String("request_id", requestid.FromContext(c)))
+		var req BatchRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			log.InfoContext(c, "Invalid request", sl.Err(err))
+			problem.Write(c, validation.Problem(err))
+			return
+		}
+		var fieldErrs []problem.FieldError
+		seen := make(map[uint64]int)
+		for i, op := range req.Operations {
+			fieldErrs = append(fieldErrs, op.check(i)...)
+			if !req.Atomic || op.Op == OpCreate || op.ID == 0 {
+				continue
+			}
+			if j, ok := seen[op.ID]; ok {
+				fieldErrs = append(fieldErrs, problem.FieldError{
+					Field:   "operations[" + strconv.Itoa(i) + "].id",
+					Message: "duplicates operations[" + strconv.Itoa(j) + "], not allowed in atomic batches",
+				})
+				continue
+			}
+			seen[op.ID] = i
+		}
+		if len(fieldErrs) > 0 {
+			problem.Write(c, problem.New(400, "invalid request").WithErrors(fieldErrs...))
+			return
+		}
+
+		b := &batch{
+			c:       c,
+			log:     log,
+			client:  client,
+			locks:   locks,
+			uid:     c.GetUint64("userID"),
+			ops:     req.Operations,
+			atomic:  req.Atomic,
+			results: make([]BatchResult, len(req.Operations)),
+			undo:    make([]func(context.Context) error, len(req.Operations)),
+		}
+		defer b.unlockAll()
+		var first, deletes [][]int
+		byTask := make(map[uint64]int)
+		for i, op := range req.Operations {
+			switch {
+			case req.Atomic && op.Op == OpDelete:
+				deletes = append(deletes, []int{i})
+			case op.Op == OpCreate:
+				first = append(first, []int{i})
+			default:
+				if n, ok := byTask[op.ID]; ok {
+					first[n] = append(first[n], i)
+					continue
+				}
+				byTask[op.ID] = len(first)
+				first = append(first, []int{i})
+			}
+		}
+		err := b.run(first)
+		if err == nil {
+			err = b.run(deletes)
+		}
+		resp := BatchResponse{Results: b.results}
+		if err != nil {
+			resp.RolledBack = b.rollback()
+		}
+
+		c.Set(middleware.CacheTagsKey, b.tags())
+		log.InfoContext(c, "Batch processed", slog.Int("operations", len(req.Operations)), slog.Bool("rolled_back", resp.RolledBack))
+		c.JSON(200, resp)
+	}
+}
+
+type batch struct {
+	c      *gin.Context
+	log    *slog.Logger
+	client taskv1.TaskServiceClient
+	locks  *lock.Locker
+	uid    uint64
+	ops    []BatchOperation
+	atomic bool
+
+	// results and undo are indexed by operation, each written only by the
+	// goroutine running that operation.
+	results []BatchResult
+	undo    []func(context.Context) error
+
+	mu      sync.Mutex
+	created []uint64
+	// held release the task locks of an atomic batch, called by unlockAll.
+	held []func()
+}
+
+// opError is a failure of an operation that did not come from the task
+// service.
+type opError struct {
+	p *problem.Problem
+}
+
+func (e *opError) Error() string {
+	return e.p.Detail
+}
+
+// run executes groups of operations in parallel, the operations of a group
+// one after another. In atomic mode the first failure cancels the rest and
+// is returned.
+func (b *batch) run(groups [][]int) error {
+	g, ctx := errgroup.WithContext(b.c)
+	g.SetLimit(batchConcurrency)
+	for _, group := range groups {
+		g.Go(func() error {
+			for _, i := range group {
+				if b.atomic && ctx.Err() != nil {
+					return nil
+				}
+				err := b.exec(ctx, i)
+				if err == nil {
+					continue
+				}
+				p := opProblem(b.c, err)
+				b.results[i] = BatchResult{Status: p.Status, Error: p}
+				if b.atomic {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// opProblem returns the problem of an operation error.
+func opProblem(c *gin.Context, err error) *problem.Problem {
+	var oe *opError
+	if errors.As(err, &oe) {
+		return oe.p
+	}
+	return grpc.Problem(c, err)
+}
+
+// lock takes the lock of the task for an operation and renews it while
+// held. Atomic batches keep it until unlockAll, so no other mutation lands
+// before a rollback, however long the batch runs.
+func (b *batch) lock(ctx context.Context, id uint64) (unlock func(), err error) {
+	l, err := acquireTask(ctx, b.locks, b.uid, id)
+	switch {
+	case ctx.Err() != nil:
+		return nil, status.FromContextError(ctx.Err()).Err()
+	case errors.Is(err, lock.ErrNotAcquired):
+		return nil, &opError{p: problem.New(409, "task is being modified, retry later")}
+	case err != nil:
+		b.log.ErrorContext(b.c, "Failed to lock task", sl.Err(err))
+		return nil, &opError{p: problem.New(503, "failed to lock task")}
+	}
+	stop := keepTask(b.c, b.log, l)
+	release := func() {
+		stop()
+		if err := l.Release(context.WithoutCancel(b.c)); err != nil {
+			b.log.ErrorContext(b.c, "Failed to unlock task", sl.Err(err))
+		}
+	}
+	if b.atomic {
+		b.mu.Lock()
+		b.held = append(b.held, release)
+		b.mu.Unlock()
+		return func() {}, nil
+	}
+	return release, nil
+}
+
+func (b *batch) unlockAll() {
+	for _, release := range b.held {
+		release()
+	}
+}
+
+func (b *batch) exec(ctx context.Context, i int) error {
+	op := b.ops[i]
+	var snapshot *taskv1.Task
+	if op.Op != OpCreate {
+		unlock, err := b.lock(ctx, op.ID)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	if op.Op != OpCreate && (b.atomic || op.IfMatch != "") {
+		resp, err := b.client.GetTask(ctx, &taskv1.GetTaskRequest{Id: op.ID, UserId: b.uid})
+		if err != nil {
+			return err
+		}
+		snapshot = resp.GetTask()
+		if op.IfMatch != "" && !ifMatch(op.IfMatch, taskETag(snapshot)) {
+			return &opError{p: problem.New(412, "task was modified, fetch it again")}
+		}
+	}
+
+	switch op.Op {
+	case OpCreate:
+		resp, err := b.client.CreateTask(ctx, &taskv1.CreateTaskRequest{
+			UserId:      b.uid,
+			Title:       op.Task.Title,
+			Description: op.Task.Description,
+			Priority:    op.Task.priority(),
+			DueDate:     dueDateToProto(op.Task.DueDate),
+		})
+		if err != nil {
+			return err
+		}
+		id := resp.GetTask().GetId()
+		b.mu.Lock()
+		b.created = append(b.created, id)
+		b.mu.Unlock()
+		b.succeed(i, 201, resp.GetTask(), func(ctx context.Context) error {
+			_, err := b.client.DeleteTask(ctx, &taskv1.DeleteTaskRequest{Id: id, UserId: b.uid})
+			return err
+		})
+	case OpUpdate:
+		resp, err := b.client.UpdateTask(ctx, &taskv1.UpdateTaskRequest{
+			Id:          op.ID,
+			UserId:      b.uid,
+			Title:       op.Task.Title,
+			Description: op.Task.Description,
+			Priority:    op.Task.priority(),
+			DueDate:     dueDateToProto(op.Task.DueDate),
+		})
+		if err != nil {
+			return err
+		}
+		b.succeed(i, 200, resp.GetTask(), func(ctx context.Context) error {
+			_, err := b.client.UpdateTask(ctx, &taskv1.UpdateTaskRequest{
+				Id:          snapshot.GetId(),
+				UserId:      b.uid,
+				Title:       snapshot.GetTitle(),
+				Description: snapshot.GetDescription(),
+				Priority:    snapshot.GetPriority(),
+				DueDate:     snapshot.GetDueDate(),
+			})
+			return err
+		})
+	case OpStatus:
+		st, _ := taskenum.ParseStatus(op.Status)
+		resp, err := b.client.UpdateStatus(ctx, &taskv1.UpdateStatusRequest{Id: op.ID, UserId: b.uid, Status: st})
+		if err != nil {
+			return err
+		}
+		b.succeed(i, 200, resp.GetTask(), func(ctx context.Context) error {
+			_, err := b.client.UpdateStatus(ctx, &taskv1.UpdateStatusRequest{
+				Id:     snapshot.GetId(),
+				UserId: b.uid,
+				Status: snapshot.GetStatus(),
+			})
+			return err
+		})
+	case OpDelete:
+		if _, err := b.client.DeleteTask(ctx, &taskv1.DeleteTaskRequest{Id: op.ID, UserId: b.uid}); err != nil {
+			return err
+		}
+		b.succeed(i, 204, nil, func(ctx context.Context) error {
+			return b.recreate(ctx, snapshot)
+		})
+	}
+	return nil
+}
+
+func (b *batch) succeed(i, status int, t *taskv1.Task, undo func(context.Context) error) {
+	res := BatchResult{Status: status}
+	if t != nil {
+		task := FromProto(t)
+		res.Task = &task
+	}
+	b.results[i] = res
+	if b.atomic {
+		b.undo[i] = undo
+	}
+}
+
+// recreate restores a deleted task as a new task with the same fields.
+func (b *batch) recreate(ctx context.Context, t *taskv1.Task) error {
+	resp, err := b.client.CreateTask(ctx, &taskv1.CreateTaskRequest{
+		UserId:      b.uid,
+		Title:       t.GetTitle(),
+		Description: t.GetDescription(),
+		Priority:    t.GetPriority(),
+		DueDate:     t.GetDueDate(),
+	})
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.created = append(b.created, resp.GetTask().GetId())
+	b.mu.Unlock()
+	if t.GetStatus() == resp.GetTask().GetStatus() {
+		return nil
+	}
+	_, err = b.client.UpdateStatus(ctx, &taskv1.UpdateStatusRequest{
+		Id:     resp.GetTask().GetId(),
+		UserId: b.uid,
+		Status: t.GetStatus(),
+	})
+	return err
+}
+
+// rollback undoes the succeeded operations in reverse order and marks every
+// operation that left no effect with 424. It reports whether everything was
+// undone.
+func (b *batch) rollback() bool {
+	ctx := context.WithoutCancel(b.c)
+	ok := true
+	for i := len(b.ops) - 1; i >= 0; i-- {
+		res := &b.results[i]
+		switch {
+		case b.undo[i] != nil:
+			if err := b.undo[i](ctx); err != nil {
+				b.log.ErrorContext(b.c, "Failed to roll back batch operation", sl.Err(err), slog.Int("index", i))
+				ok = false
+				continue
+			}
+		case res.Status == 0, res.Error != nil && res.Error.Code == codes.Canceled.String():
+		default:
+			// The failed operation keeps its own error.
+			continue
+		}
+		*res = BatchResult{Status: 424, Error: problem.New(424, "not applied, another operation of the atomic batch failed")}
+	}
+	return ok
+}
+
+// tags returns the cache tags of every task the batch touched.
+func (b *batch) tags() []string {
+	var tags []string
+	for _, op := range b.ops {
+		if op.ID != 0 {
+			tags = append(tags, TaskTag(op.ID, b.uid))
+		}
+	}
+	for _, id := range b.created {
+		tags = append(tags, TaskTag(id, b.uid))
+	}
+	tags = append(tags, ListTag(b.uid))
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
@@ -0,0 +1,247 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Citadelas/api-gateway/internal/lib/lock"
+	taskv1 "github.com/Citadelas/protos/golang/task"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const batchUser = 3
+
+// batchTask returns the seeded task with the given ID: tasks 1, 2 and 3
+// titled "a", "b" and "c".
+func batchTask(id uint64) *taskv1.Task {
+	return &taskv1.Task{
+		Id:        id,
+		UserId:    batchUser,
+		Title:     string(rune('a' + id - 1)),
+		Status:    taskv1.TaskStatus_TODO,
+		CreatedAt: timestamppb.New(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)),
+	}
+}
+
+// titles returns the sorted titles of every stored task.
+func (f *fakeTasks) titles() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var titles []string
+	for _, t := range f.tasks {
+		titles = append(titles, t.GetTitle())
+	}
+	slices.Sort(titles)
+	return titles
+}
+
+// failOn makes the method fail with Unavailable for the task ID.
+func failOn(method string, id uint64) func(string, uint64) error {
+	return func(m string, i uint64) error {
+		if m == method && i == id {
+			return status.Error(codes.Unavailable, "task service unavailable")
+		}
+		return nil
+	}
+}
+
+func TestBatchHandler(t *testing.T) {
+	etag := taskETag(batchTask(1))
+	unchanged := func(t *testing.T, tasks *fakeTasks) {
+		t.Helper()
+		for id := uint64(1); id <= 3; id++ {
+			if got := tasks.get(id); !proto.Equal(got, batchTask(id)) {
+				t.Errorf("task %d = %v, want it unchanged", id, got)
+			}
+		}
+		if got := tasks.titles(); !slices.Equal(got, []string{"a", "b", "c"}) {
+			t.Errorf("titles = %v, want [a b c]", got)
+		}
+	}
+
+	tests := []struct {
+		name           string
+		body           string
+		fail           func(method string, id uint64) error
+		wantCode       int
+		wantStatuses   []int
+		wantRolledBack bool
+		check          func(t *testing.T, tasks *fakeTasks)
+	}{
+		{
+			name: "non-atomic failure keeps the other operations",
+			body: `{"operations":[
+				{"op":"update","id":1,"task":{"title":"x"}},
+				{"op":"status","id":2,"status":"done"},
+				{"op":"create","task":{"title":"new"}}]}`,
+			fail:         failOn("UpdateStatus", 2),
+			wantCode:     200,
+			wantStatuses: []int{200, 503, 201},
+			check: func(t *testing.T, tasks *fakeTasks) {
+				if got := tasks.titles(); !slices.Equal(got, []string{"b", "c", "new", "x"}) {
+					t.Errorf("titles = %v, want [b c new x]", got)
+				}
+			},
+		},
+		{
+			name: "non-atomic operations on one task run in order",
+			body: `{"operations":[
+				{"op":"status","id":1,"status":"in_progress"},
+				{"op":"update","id":1,"task":{"title":"x"}},
+				{"op":"status","id":1,"status":"done"}]}`,
+			wantCode:     200,
+			wantStatuses: []int{200, 200, 200},
+			check: func(t *testing.T, tasks *fakeTasks) {
+				if got := tasks.get(1); got.GetStatus() != taskv1.TaskStatus_DONE || got.GetTitle() != "x" {
+					t.Errorf("task 1 = %v, want done and titled x", got)
+				}
+			},
+		},
+		{
+			name: "atomic failed delete undoes the other operations",
+			body: `{"atomic":true,"operations":[
+				{"op":"update","id":1,"task":{"title":"x"}},
+				{"op":"status","id":2,"status":"done"},
+				{"op":"create","task":{"title":"new"}},
+				{"op":"delete","id":3}]}`,
+			fail:           failOn("DeleteTask", 3),
+			wantCode:       200,
+			wantStatuses:   []int{424, 424, 424, 503},
+			wantRolledBack: true,
+			check:          unchanged,
+		},
+		{
+			name: "atomic failed update undoes the other update",
+			body: `{"atomic":true,"operations":[
+				{"op":"update","id":1,"task":{"title":"x"}},
+				{"op":"update","id":2,"task":{"title":"y"}}]}`,
+			fail:           failOn("UpdateTask", 2),
+			wantCode:       200,
+			wantStatuses:   []int{424, 503},
+			wantRolledBack: true,
+			check:          unchanged,
+		},
+		{
+			name: "atomic deleted task is recreated",
+			body: `{"atomic":true,"operations":[
+				{"op":"delete","id":1},
+				{"op":"delete","id":2}]}`,
+			fail:           failOn("DeleteTask", 2),
+			wantCode:       200,
+			wantStatuses:   []int{424, 503},
+			wantRolledBack: true,
+			check: func(t *testing.T, tasks *fakeTasks) {
+				if got := tasks.titles(); !slices.Equal(got, []string{"a", "b", "c"}) {
+					t.Errorf("titles = %v, want [a b c]", got)
+				}
+			},
+		},
+		{
+			name: "atomic duplicate task is rejected",
+			body: `{"atomic":true,"operations":[
+				{"op":"status","id":1,"status":"done"},
+				{"op":"delete","id":1}]}`,
+			wantCode: 400,
+			check:    unchanged,
+		},
+		{
+			name:         "if_match mismatch",
+			body:         `{"operations":[{"op":"update","id":1,"task":{"title":"x"},"if_match":"\"stale\""}]}`,
+			wantCode:     200,
+			wantStatuses: []int{412},
+			check:        unchanged,
+		},
+		{
+			name:         "if_match match",
+			body:         `{"operations":[{"op":"update","id":1,"task":{"title":"x"},"if_match":` + strconv.Quote(etag) + `}]}`,
+			wantCode:     200,
+			wantStatuses: []int{200},
+		},
+		{
+			name:     "missing fields",
+			body:     `{"operations":[{"op":"update","task":{"title":"x"}},{"op":"status","id":1}]}`,
+			wantCode: 400,
+			check:    unchanged,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tasks := newFakeTasks()
+			for id := uint64(1); id <= 3; id++ {
+				tasks.put(batchTask(id))
+			}
+			tasks.fail = tt.fail
+			router := gin.New()
+			router.POST("/batch", asUser(batchUser),
+				BatchHandler(slog.New(slog.NewTextHandler(io.Discard, nil)), tasks, lock.New(newTestRedis(t))))
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(tt.body)))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantCode == 200 {
+				var resp BatchResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				var statuses []int
+				for _, res := range resp.Results {
+					statuses = append(statuses, res.Status)
+				}
+				if !slices.Equal(statuses, tt.wantStatuses) {
+					t.Errorf("statuses = %v, want %v", statuses, tt.wantStatuses)
+				}
+				if resp.RolledBack != tt.wantRolledBack {
+					t.Errorf("rolled_back = %v, want %v", resp.RolledBack, tt.wantRolledBack)
+				}
+			}
+			tasks.fail = nil
+			if tt.check != nil {
+				tt.check(t, tasks)
+			}
+		})
+	}
+}
+
+func TestBatchHandlerLockedTask(t *testing.T) {
+	tasks := newFakeTasks()
+	tasks.put(batchTask(1))
+	locks := lock.New(newTestRedis(t))
+	router := gin.New()
+	router.POST("/batch", asUser(batchUser),
+		BatchHandler(slog.New(slog.NewTextHandler(io.Discard, nil)), tasks, locks))
+
+	held, err := locks.Acquire(t.Context(), taskLockKey(batchUser, 1), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = held.Release(context.Background()) })
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/batch",
+		strings.NewReader(`{"operations":[{"op":"status","id":1,"status":"done"}]}`)))
+	var resp BatchResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 1 || resp.Results[0].Status != 409 {
+		t.Fatalf("results = %+v, want one 409", resp.Results)
+	}
+	if got := tasks.get(1); !proto.Equal(got, batchTask(1)) {
+		t.Fatalf("task 1 = %v, want it unchanged", got)
+	}
+}
@@ -0,0 +1,36 @@
+package task
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TaskTag is the cache tag of a single task of a user.
+func TaskTag(id, userID uint64) string {
+	return "task:" + strconv.FormatUint(id, 10) + ":user:" + strconv.FormatUint(userID, 10)
+}
+
+// ListTag is the cache tag of the task lists of a user.
+func ListTag(userID uint64) string {
+	return "tasks:user:" + strconv.FormatUint(userID, 10)
+}
+
+// CacheTags returns the cache tags of a task route for CacheMiddleware.
+// Lists are stored under the list tag, a single task under its own tag,
+// and a mutation of any task purges both.
+func CacheTags(c *gin.Context) []string {
+	uid := c.GetUint64("userID")
+	if uid == 0 {
+		return nil
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return []string{ListTag(uid)}
+	}
+	if c.Request.Method == http.MethodGet {
+		return []string{TaskTag(id, uid)}
+	}
+	return []string{TaskTag(id, uid), ListTag(uid)}
+}
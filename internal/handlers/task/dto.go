@@ -0,0 +1,66 @@
+package task
+
+import (
+	"time"
+
+	"github.com/Citadelas/api-gateway/internal/lib/taskenum"
+	taskv1 "github.com/Citadelas/protos/golang/task"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TaskResponse is the body of every response returning a single task.
+type TaskResponse struct {
+	Task Task `json:"task"`
+}
+
+func newTaskResponse(t *taskv1.Task) TaskResponse {
+	return TaskResponse{Task: FromProto(t)}
+}
+
+// FromProto maps a taskv1 task to the public representation, so the
+// API does not change with the proto.
+func FromProto(t *taskv1.Task) Task {
+	return Task{
+		Id:          t.GetId(),
+		UserId:      t.GetUserId(),
+		Title:       t.GetTitle(),
+		Description: t.GetDescription(),
+		Priority:    taskenum.PriorityString(t.GetPriority()),
+		Status:      taskenum.StatusString(t.GetStatus()),
+		CreatedAt:   t.GetCreatedAt().AsTime().UTC(),
+		DueDate:     dueDateFromProto(t.GetDueDate()),
+	}
+}
+
+// dueDateFromProto returns nil for a missing due date. Timestamps not after
+// the Unix epoch are treated as missing: older gateway versions stored the
+// zero time.Time for tasks without a due date.
+func dueDateFromProto(ts *timestamppb.Timestamp) *time.Time {
+	if ts.GetSeconds() <= 0 {
+		return nil
+	}
+	t := ts.AsTime().UTC()
+	return &t
+}
+
+func dueDateToProto(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+// StreamTicketResponse is the body of POST /tasks/stream/ticket.
+type StreamTicketResponse struct {
+	Ticket string `json:"ticket"`
+	// ExpiresIn is the ticket lifetime in seconds.
+	ExpiresIn int `json:"expires_in"`
+}
+
+// TaskListResponse is the body of GET /tasks. NextCursor is empty on the
+// last page. Truncated reports that older tasks were not considered.
+type TaskListResponse struct {
+	Tasks      []Task `json:"tasks"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Truncated  bool   `json:"truncated,omitempty"`
+}
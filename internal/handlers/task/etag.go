@@ -0,0 +1,144 @@
+package task
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Citadelas/api-gateway/internal/helpers/grpc"
+	"github.com/Citadelas/api-gateway/internal/helpers/problem"
+	"github.com/Citadelas/api-gateway/internal/lib/lock"
+	"github.com/Citadelas/api-gateway/internal/lib/logger/sl"
+	taskv1 "github.com/Citadelas/protos/golang/task"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// taskLockTTL bounds how long a crashed read-modify-write can block
+	// other updates of the task. Running holders renew the lock.
+	taskLockTTL = 10 * time.Second
+	// taskLockWait is how long a mutation waits for another mutation of
+	// the same task before giving up with 409.
+	taskLockWait = 2 * time.Second
+)
+
+// taskETag is a strong ETag of the task state. The task proto has no
+// version, so the tag is a hash of its public representation.
+func taskETag(t *taskv1.Task) string {
+	data, _ := json.Marshal(FromProto(t))
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// writeTask responds with the task and its ETag.
+func writeTask(c *gin.Context, status int, t *taskv1.Task) {
+	c.Header("ETag", taskETag(t))
+	c.JSON(status, newTaskResponse(t))
+}
+
+// ifMatch reports whether the If-Match header value matches etag. Weak
+// tags never match, as RFC 9110 requires strong comparison.
+func ifMatch(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// lockTask takes the per-task lock held by every task mutation. When the
+// lock is not taken it responds with 409 or 503 and returns nil.
+func lockTask(c *gin.Context, log *slog.Logger, locks *lock.Locker, uid, id uint64) func() {
+	l, err := acquireTask(c, locks, uid, id)
+	if errors.Is(err, lock.ErrNotAcquired) {
+		problem.Abort(c, 409, "task is being modified, retry later")
+		return nil
+	}
+	if err != nil {
+		log.ErrorContext(c, "Failed to lock task", sl.Err(err))
+		problem.Abort(c, 503, "failed to lock task")
+		return nil
+	}
+	stop := keepTask(c, log, l)
+	return func() {
+		stop()
+		if err := l.Release(context.WithoutCancel(c)); err != nil {
+			log.ErrorContext(c, "Failed to unlock task", sl.Err(err))
+		}
+	}
+}
+
+// keepTask renews a task lock until the returned stop is called, so slow
+// backend calls with retries cannot outlive it.
+func keepTask(ctx context.Context, log *slog.Logger, l *lock.Lock) (stop func()) {
+	return l.KeepAlive(taskLockTTL, func(err error) {
+		log.ErrorContext(ctx, "Failed to renew task lock", sl.Err(err))
+	})
+}
+
+// acquireTask takes the lock of the task, waiting up to taskLockWait for
+// the current holder.
+func acquireTask(ctx context.Context, locks *lock.Locker, uid, id uint64) (*lock.Lock, error) {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(taskLockWait)
+	for {
+		l, err := locks.Acquire(ctx, taskLockKey(uid, id), taskLockTTL)
+		if !errors.Is(err, lock.ErrNotAcquired) {
+			return l, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timeout:
+			return nil, err
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkIfMatch locks the task for a mutation and enforces its If-Match
+// precondition. The task service cannot compare versions, so the gateway
+// reads the task under the lock and compares ETags itself. Every mutation
+// takes the lock, so none can slip in between the check and the write;
+// the caller must write before calling the returned unlock. Without
+// If-Match only the lock is taken. ok is false when a response was
+// already written.
+func checkIfMatch(c *gin.Context, log *slog.Logger, client taskv1.TaskServiceClient, locks *lock.Locker, id, uid uint64) (unlock func(), ok bool) {
+	unlock = lockTask(c, log, locks, uid, id)
+	if unlock == nil {
+		return nil, false
+	}
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return unlock, true
+	}
+	resp, err := client.GetTask(c, &taskv1.GetTaskRequest{Id: id, UserId: uid})
+	if err != nil {
+		unlock()
+		log.ErrorContext(c, "Error making grpc get task request", sl.Err(err))
+		grpc.HandleGRPCError(c, err)
+		return nil, false
+	}
+	if etag := taskETag(resp.GetTask()); !ifMatch(header, etag) {
+		unlock()
+		c.Header("ETag", etag)
+		problem.Abort(c, 412, "task was modified, fetch it again")
+		return nil, false
+	}
+	return unlock, true
+}
+
+// taskLockKey includes the user, so nobody can block the tasks of others
+// by sending writes for their IDs.
+func taskLockKey(uid, id uint64) string {
+	return "lock:task:" + strconv.FormatUint(uid, 10) + ":" + strconv.FormatUint(id, 10)
+}
@@ -0,0 +1,165 @@
+package task
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/Citadelas/api-gateway/internal/helpers/validation"
+	taskv1 "github.com/Citadelas/protos/golang/task"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	if err := validation.Register(); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// fakeTasks is an in-memory task service scoped by user like the real one.
+type fakeTasks struct {
+	mu     sync.Mutex
+	nextID uint64
+	tasks  map[uint64]*taskv1.Task
+	// fail injects an error for a method and task ID, nil lets the call run.
+	fail func(method string, id uint64) error
+}
+
+func newFakeTasks() *fakeTasks {
+	return &fakeTasks{nextID: 1000, tasks: make(map[uint64]*taskv1.Task)}
+}
+
+// put stores a copy of t as is.
+func (f *fakeTasks) put(t *taskv1.Task) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tasks[t.GetId()] = proto.Clone(t).(*taskv1.Task)
+}
+
+// get returns a copy of the stored task, nil when there is none.
+func (f *fakeTasks) get(id uint64) *taskv1.Task {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if t, ok := f.tasks[id]; ok {
+		return proto.Clone(t).(*taskv1.Task)
+	}
+	return nil
+}
+
+func (f *fakeTasks) injected(method string, id uint64) error {
+	if f.fail == nil {
+		return nil
+	}
+	return f.fail(method, id)
+}
+
+// owned must be called with mu held.
+func (f *fakeTasks) owned(id, userID uint64) (*taskv1.Task, error) {
+	t, ok := f.tasks[id]
+	if !ok || t.GetUserId() != userID {
+		return nil, status.Error(codes.NotFound, "task not found")
+	}
+	return t, nil
+}
+
+func (f *fakeTasks) CreateTask(_ context.Context, in *taskv1.CreateTaskRequest, _ ...grpc.CallOption) (*taskv1.CreateTaskResponse, error) {
+	if err := f.injected("CreateTask", 0); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	t := &taskv1.Task{
+		Id:          f.nextID,
+		UserId:      in.GetUserId(),
+		Title:       in.GetTitle(),
+		Description: in.GetDescription(),
+		Priority:    in.GetPriority(),
+		Status:      taskv1.TaskStatus_TODO,
+		CreatedAt:   timestamppb.Now(),
+		DueDate:     in.GetDueDate(),
+	}
+	f.tasks[t.Id] = t
+	return &taskv1.CreateTaskResponse{Task: proto.Clone(t).(*taskv1.Task)}, nil
+}
+
+func (f *fakeTasks) GetTask(_ context.Context, in *taskv1.GetTaskRequest, _ ...grpc.CallOption) (*taskv1.GetTaskResponse, error) {
+	if err := f.injected("GetTask", in.GetId()); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, err := f.owned(in.GetId(), in.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+	return &taskv1.GetTaskResponse{Task: proto.Clone(t).(*taskv1.Task)}, nil
+}
+
+func (f *fakeTasks) UpdateTask(_ context.Context, in *taskv1.UpdateTaskRequest, _ ...grpc.CallOption) (*taskv1.UpdateTaskResponse, error) {
+	if err := f.injected("UpdateTask", in.GetId()); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, err := f.owned(in.GetId(), in.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+	t.Title, t.Description, t.Priority, t.DueDate = in.GetTitle(), in.GetDescription(), in.GetPriority(), in.GetDueDate()
+	return &taskv1.UpdateTaskResponse{Task: proto.Clone(t).(*taskv1.Task)}, nil
+}
+
+func (f *fakeTasks) UpdateStatus(_ context.Context, in *taskv1.UpdateStatusRequest, _ ...grpc.CallOption) (*taskv1.UpdateStatusResponse, error) {
+	if err := f.injected("UpdateStatus", in.GetId()); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, err := f.owned(in.GetId(), in.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+	t.Status = in.GetStatus()
+	return &taskv1.UpdateStatusResponse{Task: proto.Clone(t).(*taskv1.Task)}, nil
+}
+
+func (f *fakeTasks) DeleteTask(_ context.Context, in *taskv1.DeleteTaskRequest, _ ...grpc.CallOption) (*emptypb.Empty, error) {
+	if err := f.injected("DeleteTask", in.GetId()); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.owned(in.GetId(), in.GetUserId()); err != nil {
+		return nil, err
+	}
+	delete(f.tasks, in.GetId())
+	return &emptypb.Empty{}, nil
+}
+
+func newTestRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+// asUser authenticates every request as the user, like AuthMiddleware.
+func asUser(userID uint64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("userID", userID)
+		c.Next()
+	}
+}
@@ -0,0 +1,282 @@
+package task
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Citadelas/api-gateway/internal/helpers/grpc"
+	"github.com/Citadelas/api-gateway/internal/helpers/problem"
+	"github.com/Citadelas/api-gateway/internal/helpers/validation"
+	"github.com/Citadelas/api-gateway/internal/lib/logger/sl"
+	"github.com/Citadelas/api-gateway/internal/lib/requestid"
+	"github.com/Citadelas/api-gateway/internal/lib/taskenum"
+	"github.com/Citadelas/api-gateway/internal/lib/taskindex"
+	taskv1 "github.com/Citadelas/protos/golang/task"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultListLimit = 20
+	// listScanFactor times the page size is how many indexed tasks a list
+	// request reads from the task service.
+	listScanFactor = 5
+	// listConcurrency bounds parallel GetTask calls of one list request.
+	listConcurrency = 16
+)
+
+// ListQuery holds the filters, sort order and page of GET /tasks.
+type ListQuery struct {
+	Status    string     `form:"status" binding:"omitempty,task_status"`
+	Priority  string     `form:"priority" binding:"omitempty,task_priority"`
+	DueBefore *time.Time `form:"due_before" time_format:"2006-01-02T15:04:05Z07:00"`
+	DueAfter  *time.Time `form:"due_after" time_format:"2006-01-02T15:04:05Z07:00"`
+	// Q is a case-insensitive substring of the title or description.
+	Q string `form:"q" binding:"max=200"`
+	// Sort is a field name, "-" prefix sorts descending.
+	Sort   string `form:"sort" binding:"omitempty,oneof=created_at -created_at due_date -due_date priority -priority title -title"`
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=100"`
+	Cursor string `form:"cursor"`
+}
+
+// listCursor points after the last task of the previous page. Hash ties it
+// to the filters and sort order it was issued for.
+type listCursor struct {
+	Key  string `json:"k"`
+	ID   uint64 `json:"id"`
+	Hash string `json:"h"`
+}
+
+// ListTasksHandler lists the tasks of the caller. The task service has no
+// list RPC, so the handler reads a window of the tasks known to the index
+// through GetTask, then filters, sorts and pages them in the gateway.
+//
+// Sorted by created_at the window follows the cursor, so paging reaches
+// every indexed task. Other sort orders only see the newest tasks of the
+// window; the response is marked truncated when older tasks were left out.
+func ListTasksHandler(log *slog.Logger, client taskv1.TaskServiceClient, index *taskindex.Index) gin.HandlerFunc {
+	const op = "handlers.task.List"
+	log = log.With("op", op)
+	return func(c *gin.Context) {
+		log := log.With(slog.String("request_id", requestid.FromContext(c)))
+		uid := c.GetUint64("userID")
+		var q ListQuery
+		if err := c.ShouldBindQuery(&q); err != nil {
+			log.InfoContext(c, "Invalid request", sl.Err(err))
+			problem.Write(c, validation.Problem(err))
+			return
+		}
+		if q.Sort == "" {
+			q.Sort = "-created_at"
+		}
+		if q.Limit == 0 {
+			q.Limit = defaultListLimit
+		}
+		var after *listCursor
+		if q.Cursor != "" {
+			cur, err := decodeCursor(q.Cursor)
+			if err != nil || cur.Hash != q.hash() {
+				problem.Write(c, problem.New(400, "invalid request").WithErrors(problem.FieldError{
+					Field:   "cursor",
+					Message: "is invalid or does not match the query",
+				}))
+				return
+			}
+			after = cur
+		}
+
+		field, desc := strings.CutPrefix(q.Sort, "-")
+		// Task IDs grow with creation time, so created_at pages map to
+		// ranges of the index.
+		byID := field == "created_at"
+		var from uint64
+		if byID && after != nil {
+			from = after.ID
+		}
+		scan := q.Limit * listScanFactor
+		ids, err := index.IDs(c, uid, from, !byID || desc, scan)
+		if err != nil {
+			log.ErrorContext(c, "Failed to read task index", sl.Err(err))
+			problem.Abort(c, 503, "failed to list tasks")
+			return
+		}
+
+		tasks := make([]*taskv1.Task, len(ids))
+		g, ctx := errgroup.WithContext(c)
+		g.SetLimit(listConcurrency)
+		for i, id := range ids {
+			g.Go(func() error {
+				resp, err := client.GetTask(ctx, &taskv1.GetTaskRequest{Id: id, UserId: uid})
+				// The index may lag behind tasks deleted elsewhere.
+				if status.Code(err) == codes.NotFound {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				tasks[i] = resp.GetTask()
+				return nil
+			})
+		}
+		if err := g.Wait(); err != nil {
+			log.ErrorContext(c, "Error making grpc get task request", sl.Err(err))
+			grpc.HandleGRPCError(c, err)
+			return
+		}
+
+		items := make([]Task, 0, len(tasks))
+		for _, t := range tasks {
+			if t != nil && q.match(t) {
+				items = append(items, FromProto(t))
+			}
+		}
+		slices.SortFunc(items, func(a, b Task) int {
+			r := cmp.Or(strings.Compare(sortKey(a, field), sortKey(b, field)), cmp.Compare(a.Id, b.Id))
+			if desc {
+				return -r
+			}
+			return r
+		})
+
+		start := 0
+		if after != nil {
+			start, _ = slices.BinarySearchFunc(items, after, func(t Task, cur *listCursor) int {
+				r := cmp.Or(strings.Compare(sortKey(t, field), cur.Key), cmp.Compare(t.Id, cur.ID))
+				if desc {
+					r = -r
+				}
+				// Items equal to the cursor belong to the previous page.
+				if r == 0 {
+					return -1
+				}
+				return r
+			})
+		}
+		end := min(start+q.Limit, len(items))
+
+		resp := TaskListResponse{Tasks: items[start:end]}
+		windowFull := len(ids) == scan
+		switch {
+		case end < len(items):
+			last := items[end-1]
+			resp.NextCursor = encodeCursor(listCursor{Key: sortKey(last, field), ID: last.Id, Hash: q.hash()})
+		case byID && windowFull:
+			// The filters left the page short, continue after the window.
+			if last := lastTask(tasks); last != nil {
+				t := FromProto(last)
+				resp.NextCursor = encodeCursor(listCursor{Key: sortKey(t, field), ID: t.Id, Hash: q.hash()})
+			}
+		case windowFull:
+			resp.Truncated = true
+		}
+		c.JSON(200, resp)
+	}
+}
+
+// lastTask returns the last task of the window the service still knows.
+func lastTask(tasks []*taskv1.Task) *taskv1.Task {
+	for i := len(tasks) - 1; i >= 0; i-- {
+		if tasks[i] != nil {
+			return tasks[i]
+		}
+	}
+	return nil
+}
+
+func (q ListQuery) match(t *taskv1.Task) bool {
+	if q.Status != "" {
+		if st, _ := taskenum.ParseStatus(q.Status); st != t.GetStatus() {
+			return false
+		}
+	}
+	if q.Priority != "" {
+		if p, _ := taskenum.ParsePriority(q.Priority); p != t.GetPriority() {
+			return false
+		}
+	}
+	if q.DueBefore != nil || q.DueAfter != nil {
+		due := dueDateFromProto(t.GetDueDate())
+		if due == nil {
+			return false
+		}
+		if q.DueBefore != nil && !due.Before(*q.DueBefore) {
+			return false
+		}
+		if q.DueAfter != nil && !due.After(*q.DueAfter) {
+			return false
+		}
+	}
+	if q.Q != "" {
+		needle := strings.ToLower(q.Q)
+		if !strings.Contains(strings.ToLower(t.GetTitle()), needle) &&
+			!strings.Contains(strings.ToLower(t.GetDescription()), needle) {
+			return false
+		}
+	}
+	return true
+}
+
+// hash identifies the filters and sort order, but not the page.
+func (q ListQuery) hash() string {
+	var b strings.Builder
+	for _, v := range []string{q.Status, q.Priority, formatTime(q.DueBefore), formatTime(q.DueAfter), q.Q, q.Sort} {
+		b.WriteString(v)
+		b.WriteByte(0)
+	}
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:8])
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// sortKey returns the sort field of t as a string ordered like the field.
+// Tasks without a due date sort after every date.
+func sortKey(t Task, field string) string {
+	const timeLayout = "2006-01-02T15:04:05.000000000Z"
+	switch field {
+	case "due_date":
+		if t.DueDate == nil {
+			return "~"
+		}
+		return t.DueDate.UTC().Format(timeLayout)
+	case "priority":
+		p, _ := taskenum.ParsePriority(t.Priority)
+		return strconv.Itoa(int(p))
+	case "title":
+		return strings.ToLower(t.Title)
+	default:
+		return t.CreatedAt.UTC().Format(timeLayout)
+	}
+}
+
+func encodeCursor(cur listCursor) string {
+	b, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*listCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var cur listCursor
+	if err := json.Unmarshal(b, &cur); err != nil {
+		return nil, err
+	}
+	return &cur, nil
+}
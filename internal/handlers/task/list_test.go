@@ -0,0 +1,170 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Citadelas/api-gateway/internal/lib/taskindex"
+	taskv1 "github.com/Citadelas/protos/golang/task"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const listUser = 7
+
+// seedList stores tasks 1..n of listUser, newer IDs created later, and
+// indexes them together with an ID the service does not know.
+func seedList(t *testing.T, n int) (*fakeTasks, *taskindex.Index) {
+	t.Helper()
+	tasks := newFakeTasks()
+	index := taskindex.New(newTestRedis(t))
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for id := uint64(1); id <= uint64(n); id++ {
+		st := taskv1.TaskStatus_TODO
+		if id%4 == 0 {
+			st = taskv1.TaskStatus_DONE
+		}
+		tasks.put(&taskv1.Task{
+			Id:        id,
+			UserId:    listUser,
+			Title:     fmt.Sprintf("t%02d", id*7%uint64(n)),
+			Priority:  taskv1.TaskPriority(id % 3),
+			Status:    st,
+			CreatedAt: timestamppb.New(base.Add(time.Duration(id) * time.Hour)),
+		})
+		if err := index.Add(context.Background(), listUser, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Deleted outside the gateway, must be skipped.
+	if err := index.Add(context.Background(), listUser, uint64(n)+50); err != nil {
+		t.Fatal(err)
+	}
+	return tasks, index
+}
+
+// listAll follows next_cursor from the first page and returns the IDs of
+// every page in order.
+func listAll(t *testing.T, router *gin.Engine, query url.Values) (ids []uint64, truncated bool) {
+	t.Helper()
+	for pages := 0; ; pages++ {
+		if pages > 50 {
+			t.Fatal("paging does not end")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/tasks?"+query.Encode(), nil))
+		if w.Code != 200 {
+			t.Fatalf("page %d: status %d: %s", pages, w.Code, w.Body)
+		}
+		var resp TaskListResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		for _, task := range resp.Tasks {
+			ids = append(ids, task.Id)
+		}
+		truncated = truncated || resp.Truncated
+		if resp.NextCursor == "" {
+			return ids, truncated
+		}
+		query.Set("cursor", resp.NextCursor)
+	}
+}
+
+func TestListTasksPaging(t *testing.T) {
+	const n = 25
+	tasks, index := seedList(t, n)
+	router := gin.New()
+	router.GET("/tasks", asUser(listUser), ListTasksHandler(slog.New(slog.NewTextHandler(io.Discard, nil)), tasks, index))
+
+	all := func(keep func(id uint64) bool) []uint64 {
+		var ids []uint64
+		for id := uint64(1); id <= n; id++ {
+			if keep == nil || keep(id) {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+	reversed := func(ids []uint64) []uint64 {
+		ids = slices.Clone(ids)
+		slices.Reverse(ids)
+		return ids
+	}
+	byTitle := func(ids []uint64) []uint64 {
+		ids = slices.Clone(ids)
+		slices.SortFunc(ids, func(a, b uint64) int { return int(a*7%n) - int(b*7%n) })
+		return ids
+	}
+
+	tests := []struct {
+		name          string
+		query         string
+		want          []uint64
+		wantTruncated bool
+	}{
+		{name: "newest first by default", query: "limit=7", want: reversed(all(nil))},
+		{name: "oldest first", query: "sort=created_at&limit=4", want: all(nil)},
+		{name: "sparse filter pages past the scan window", query: "status=done&limit=2", want: reversed(all(func(id uint64) bool { return id%4 == 0 }))},
+		{name: "sparse filter ascending", query: "status=done&sort=created_at&limit=1", want: all(func(id uint64) bool { return id%4 == 0 })},
+		{name: "other sort within the window", query: "sort=title&limit=6", want: byTitle(all(nil))},
+		// The window holds the 10 newest index entries, one of them deleted.
+		{name: "other sort beyond the window is truncated", query: "sort=title&limit=2", want: byTitle(all(func(id uint64) bool { return id > n-9 })), wantTruncated: true},
+		{name: "descending other sort", query: "sort=-title&limit=10", want: reversed(byTitle(all(nil)))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, truncated := listAll(t, router, query)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ids = %v, want %v", got, tt.want)
+			}
+			if truncated != tt.wantTruncated {
+				t.Errorf("truncated = %v, want %v", truncated, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestListTasksCursorErrors(t *testing.T) {
+	tasks, index := seedList(t, 5)
+	router := gin.New()
+	router.GET("/tasks", asUser(listUser), ListTasksHandler(slog.New(slog.NewTextHandler(io.Discard, nil)), tasks, index))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/tasks?limit=2", nil))
+	var first TaskListResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &first); err != nil || first.NextCursor == "" {
+		t.Fatalf("first page: %v %s", err, w.Body)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{name: "garbage cursor", query: "cursor=not-a-cursor"},
+		{name: "cursor of other filters", query: "status=done&cursor=" + first.NextCursor},
+		{name: "cursor of other sort", query: "sort=title&cursor=" + first.NextCursor},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/tasks?"+tt.query, nil))
+			if w.Code != 400 {
+				t.Fatalf("status = %d, want 400", w.Code)
+			}
+		})
+	}
+}
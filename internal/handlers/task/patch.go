@@ -0,0 +1,233 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mime"
+	"strconv"
+	"time"
+
+	"github.com/Citadelas/api-gateway/internal/helpers/grpc"
+	"github.com/Citadelas/api-gateway/internal/helpers/problem"
+	"github.com/Citadelas/api-gateway/internal/helpers/validation"
+	"github.com/Citadelas/api-gateway/internal/lib/lock"
+	"github.com/Citadelas/api-gateway/internal/lib/logger/sl"
+	"github.com/Citadelas/api-gateway/internal/lib/requestid"
+	"github.com/Citadelas/api-gateway/internal/lib/taskenum"
+	taskv1 "github.com/Citadelas/protos/golang/task"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// MergePatchContentType is the media type of JSON Merge Patch (RFC 7396).
+const MergePatchContentType = "application/merge-patch+json"
+
+// TaskPatch is a JSON Merge Patch of a task. Absent fields are left
+// untouched, null clears description and due_date.
+type TaskPatch struct {
+	Title       *string    `json:"title" binding:"omitempty,min=1,max=255"`
+	Description *string    `json:"description" binding:"omitempty,max=4096"`
+	Priority    *string    `json:"priority" binding:"omitempty,task_priority"`
+	Status      *string    `json:"status" binding:"omitempty,task_status"`
+	DueDate     *time.Time `json:"due_date" binding:"omitempty,future"`
+
+	clearDescription bool
+	clearDueDate     bool
+}
+
+// nullable lists the fields that may be cleared with null.
+var nullable = map[string]bool{"description": true, "due_date": true}
+
+// decodeTaskPatch parses and validates a merge patch body.
+func decodeTaskPatch(body []byte) (*TaskPatch, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return nil, &patchError{detail: "patch must be a JSON object"}
+		}
+		return nil, err
+	}
+
+	var fieldErrs []problem.FieldError
+	patch := &TaskPatch{}
+	for field, value := range raw {
+		switch field {
+		case "title", "description", "priority", "status", "due_date":
+		default:
+			fieldErrs = append(fieldErrs, problem.FieldError{Field: field, Message: "cannot be patched"})
+			continue
+		}
+		if bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+			if !nullable[field] {
+				fieldErrs = append(fieldErrs, problem.FieldError{Field: field, Message: "cannot be null"})
+			}
+			patch.clearDescription = patch.clearDescription || field == "description"
+			patch.clearDueDate = patch.clearDueDate || field == "due_date"
+		}
+	}
+	if len(fieldErrs) > 0 {
+		return nil, &patchError{detail: "invalid request", errs: fieldErrs}
+	}
+
+	if err := json.Unmarshal(body, patch); err != nil {
+		return nil, err
+	}
+	if err := binding.Validator.ValidateStruct(patch); err != nil {
+		return nil, err
+	}
+	return patch, nil
+}
+
+type patchError struct {
+	detail string
+	errs   []problem.FieldError
+}
+
+func (e *patchError) Error() string {
+	return e.detail
+}
+
+// hasFields reports whether the patch touches fields written by UpdateTask.
+func (p *TaskPatch) hasFields() bool {
+	return p.Title != nil || p.Description != nil || p.Priority != nil || p.DueDate != nil ||
+		p.clearDescription || p.clearDueDate
+}
+
+// apply returns an UpdateTask request writing the patched fields over cur.
+func (p *TaskPatch) apply(cur *taskv1.Task) *taskv1.UpdateTaskRequest {
+	req := &taskv1.UpdateTaskRequest{
+		Id:          cur.GetId(),
+		UserId:      cur.GetUserId(),
+		Title:       cur.GetTitle(),
+		Description: cur.GetDescription(),
+		Priority:    cur.GetPriority(),
+		DueDate:     cur.GetDueDate(),
+	}
+	if p.Title != nil {
+		req.Title = *p.Title
+	}
+	if p.Description != nil {
+		req.Description = *p.Description
+	}
+	if p.clearDescription {
+		req.Description = ""
+	}
+	if p.Priority != nil {
+		req.Priority, _ = taskenum.ParsePriority(*p.Priority)
+	}
+	if p.DueDate != nil {
+		req.DueDate = dueDateToProto(p.DueDate)
+	}
+	if p.clearDueDate {
+		req.DueDate = nil
+	}
+	return req
+}
+
+// restorePatched writes the fields of the task as read before the patch
+// back, so a patch failing at the status change is not partially applied.
+// It still runs when the client is gone and reports whether it succeeded.
+func restorePatched(c *gin.Context, log *slog.Logger, client taskv1.TaskServiceClient, prev *taskv1.Task) bool {
+	ctx := context.WithoutCancel(c)
+	if _, err := client.UpdateTask(ctx, (&TaskPatch{}).apply(prev)); err != nil {
+		log.ErrorContext(c, "Failed to restore task after failed patch", sl.Err(err))
+		return false
+	}
+	return true
+}
+
+// PatchTaskHandler applies a JSON Merge Patch to a task. The task service
+// only supports full updates, so the handler reads the task, merges the
+// patch and writes it back while holding the per-task lock, so concurrent
+// mutations of the task cannot overwrite each other. If-Match is honored
+// like in the other mutations. Fields and status are separate calls; when
+// the status change fails the fields are restored.
+func PatchTaskHandler(log *slog.Logger, client taskv1.TaskServiceClient, locks *lock.Locker) gin.HandlerFunc {
+	const op = "handlers.task.Patch"
+	log = log.With("op", op)
+	return func(c *gin.Context) {
+		log := log.With(slog.String("request_id", requestid.FromContext(c)))
+		uid := c.GetUint64("userID")
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			problem.Abort(c, 400, "invalid id")
+			return
+		}
+		if ct, _, _ := mime.ParseMediaType(c.ContentType()); ct != MergePatchContentType && ct != "application/json" {
+			problem.Abort(c, 415, "expected "+MergePatchContentType)
+			return
+		}
+		body, err := c.GetRawData()
+		if err != nil {
+			problem.Abort(c, 400, "failed to read body")
+			return
+		}
+		patch, err := decodeTaskPatch(body)
+		if err != nil {
+			log.InfoContext(c, "Invalid patch", sl.Err(err))
+			var pe *patchError
+			if errors.As(err, &pe) {
+				problem.Write(c, problem.New(400, pe.detail).WithErrors(pe.errs...))
+				return
+			}
+			problem.Write(c, validation.Problem(err))
+			return
+		}
+
+		unlock := lockTask(c, log, locks, uid, id)
+		if unlock == nil {
+			return
+		}
+		defer unlock()
+
+		cur, err := client.GetTask(c, &taskv1.GetTaskRequest{Id: id, UserId: uid})
+		if err != nil {
+			log.ErrorContext(c, "Error making grpc get task request", sl.Err(err))
+			grpc.HandleGRPCError(c, err)
+			return
+		}
+		task := cur.GetTask()
+		if header := c.GetHeader("If-Match"); header != "" {
+			if etag := taskETag(task); !ifMatch(header, etag) {
+				c.Header("ETag", etag)
+				problem.Abort(c, 412, "task was modified, fetch it again")
+				return
+			}
+		}
+
+		if patch.hasFields() {
+			resp, err := client.UpdateTask(c, patch.apply(task))
+			if err != nil {
+				log.ErrorContext(c, "Error making grpc update task request", sl.Err(err))
+				grpc.HandleGRPCError(c, err)
+				return
+			}
+			task = resp.GetTask()
+		}
+		if patch.Status != nil {
+			status, _ := taskenum.ParseStatus(*patch.Status)
+			if status != task.GetStatus() {
+				resp, err := client.UpdateStatus(c, &taskv1.UpdateStatusRequest{Id: id, UserId: uid, Status: status})
+				if err != nil {
+					log.ErrorContext(c, "Error making grpc update task status request", sl.Err(err))
+					if patch.hasFields() && !restorePatched(c, log, client, cur.GetTask()) {
+						p := grpc.Problem(c, err)
+						p.Detail += " (task fields were updated, status was not)"
+						problem.Write(c, p)
+						return
+					}
+					grpc.HandleGRPCError(c, err)
+					return
+				}
+				task = resp.GetTask()
+			}
+		}
+
+		log.InfoContext(c, "Task patched successfully")
+		writeTask(c, 200, task)
+	}
+}
@@ -0,0 +1,288 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Citadelas/api-gateway/internal/helpers/problem"
+	"github.com/Citadelas/api-gateway/internal/lib/logger/sl"
+	"github.com/Citadelas/api-gateway/internal/lib/requestid"
+	"github.com/Citadelas/api-gateway/internal/lib/revocation"
+	"github.com/Citadelas/api-gateway/internal/lib/taskevents"
+	"github.com/Citadelas/api-gateway/internal/lib/ticket"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// wsWriteTimeout bounds a single write to a WebSocket client.
+const wsWriteTimeout = 10 * time.Second
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// StreamTasksHandler streams the task events of the user as Server-Sent
+// Events. A client reconnecting with Last-Event-ID (or ?last_event_id=)
+// first receives the events it missed, or a stream.reset event when some of
+// them are no longer kept and the tasks have to be fetched again.
+// A client that does not keep up is disconnected and expected to resume.
+// The stream ends when the token it was opened with expires or is revoked,
+// which is checked on every heartbeat.
+func StreamTasksHandler(log *slog.Logger, hub *taskevents.Hub, denylist *revocation.Store, heartbeat time.Duration) gin.HandlerFunc {
+	const op = "handlers.task.Stream"
+	log = log.With("op", op)
+	return func(c *gin.Context) {
+		log := log.With(slog.String("request_id", requestid.FromContext(c)))
+		sub, backlog, ok := subscribe(c, log, hub)
+		if !ok {
+			return
+		}
+		defer sub.Close()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(200)
+		c.Writer.Flush()
+
+		floor := resumeFloor(lastEventID(c), backlog)
+		write := func(e taskevents.Event) error {
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+			return nil
+		}
+		for _, e := range backlog {
+			if err := write(e); err != nil {
+				return
+			}
+		}
+
+		session := c.MustGet("session").(revocation.Session)
+		expired, stopExpiry := expiry(session)
+		defer stopExpiry()
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case <-sub.Done:
+				log.InfoContext(c, "Task event stream closed")
+				return
+			case <-expired:
+				log.InfoContext(c, "Task event stream closed", slog.String("reason", "token expired"))
+				return
+			case <-ticker.C:
+				if reason, _ := checkSession(c, log, denylist, session); reason != "" {
+					log.InfoContext(c, "Task event stream closed", slog.String("reason", reason))
+					return
+				}
+				if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+					return
+				}
+				c.Writer.Flush()
+			case e := <-sub.C:
+				if floor != "" && !taskevents.After(e.ID, floor) {
+					continue
+				}
+				if err := write(e); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// TaskSocketHandler is the WebSocket equivalent of StreamTasksHandler.
+// Every event is sent as a JSON text message, resuming works with
+// ?last_event_id= and the connection is kept alive with pings. The socket
+// is closed with 1008 when the token expires or is revoked.
+func TaskSocketHandler(log *slog.Logger, hub *taskevents.Hub, denylist *revocation.Store, heartbeat time.Duration) gin.HandlerFunc {
+	const op = "handlers.task.Socket"
+	log = log.With("op", op)
+	return func(c *gin.Context) {
+		log := log.With(slog.String("request_id", requestid.FromContext(c)))
+		sub, backlog, ok := subscribe(c, log, hub)
+		if !ok {
+			return
+		}
+		defer sub.Close()
+
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			// The upgrader has already replied.
+			log.InfoContext(c, "Failed to upgrade to WebSocket", sl.Err(err))
+			return
+		}
+		defer conn.Close()
+
+		// Clients are not expected to send anything, reading only handles
+		// control frames and notices a vanished client via the deadline.
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			conn.SetReadLimit(512)
+			_ = conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+			conn.SetPongHandler(func(string) error {
+				return conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+			})
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		floor := resumeFloor(lastEventID(c), backlog)
+		write := func(e taskevents.Event) error {
+			_ = conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			return conn.WriteJSON(e)
+		}
+		closeWith := func(code int, text string) {
+			msg := websocket.FormatCloseMessage(code, text)
+			_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteTimeout))
+		}
+		for _, e := range backlog {
+			if err := write(e); err != nil {
+				return
+			}
+		}
+
+		session := c.MustGet("session").(revocation.Session)
+		expired, stopExpiry := expiry(session)
+		defer stopExpiry()
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-closed:
+				return
+			case <-sub.Done:
+				log.InfoContext(c, "Task event socket closed")
+				closeWith(websocket.CloseTryAgainLater, "reconnect with last_event_id")
+				return
+			case <-expired:
+				log.InfoContext(c, "Task event socket closed", slog.String("reason", "token expired"))
+				closeWith(websocket.ClosePolicyViolation, "token expired")
+				return
+			case <-ticker.C:
+				if reason, retry := checkSession(c, log, denylist, session); reason != "" {
+					log.InfoContext(c, "Task event socket closed", slog.String("reason", reason))
+					if retry {
+						closeWith(websocket.CloseTryAgainLater, reason)
+					} else {
+						closeWith(websocket.ClosePolicyViolation, reason)
+					}
+					return
+				}
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+					return
+				}
+			case e := <-sub.C:
+				if floor != "" && !taskevents.After(e.ID, floor) {
+					continue
+				}
+				if err := write(e); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// StreamTicketHandler issues a single-use ticket for opening a task event
+// stream with ?ticket=, for browser clients that cannot send the
+// Authorization header.
+func StreamTicketHandler(log *slog.Logger, tickets *ticket.Store) gin.HandlerFunc {
+	const op = "handlers.task.StreamTicket"
+	log = log.With("op", op)
+	return func(c *gin.Context) {
+		log := log.With(slog.String("request_id", requestid.FromContext(c)))
+		t, err := tickets.Issue(c, c.MustGet("session").(revocation.Session))
+		if err != nil {
+			log.ErrorContext(c, "Failed to issue stream ticket", sl.Err(err))
+			problem.Abort(c, 503, "failed to issue ticket")
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(200, StreamTicketResponse{Ticket: t, ExpiresIn: int(tickets.TTL().Seconds())})
+	}
+}
+
+// expiry fires when the token of a stream expires, never for tokens
+// without exp.
+func expiry(session revocation.Session) (<-chan time.Time, func()) {
+	if session.ExpiresAt == 0 {
+		return nil, func() {}
+	}
+	t := time.NewTimer(time.Until(time.Unix(session.ExpiresAt, 0)))
+	return t.C, func() { t.Stop() }
+}
+
+// checkSession checks again whether the token of a stream was revoked. It
+// returns why the stream must end, "" when it may go on, and whether the
+// client may retry. A failed check ends the stream, like AuthMiddleware
+// rejects the request.
+func checkSession(c *gin.Context, log *slog.Logger, denylist *revocation.Store, session revocation.Session) (reason string, retry bool) {
+	revoked, err := denylist.IsSessionRevoked(c, session)
+	if err != nil {
+		log.ErrorContext(c, "Failed to check token revocation", sl.Err(err))
+		return "failed to check token revocation", true
+	}
+	if revoked {
+		return "token revoked", false
+	}
+	return "", false
+}
+
+// subscribe subscribes to the events of the user before loading the missed
+// ones, so none is lost in between; duplicates are skipped by ID.
+func subscribe(c *gin.Context, log *slog.Logger, hub *taskevents.Hub) (*taskevents.Subscription, []taskevents.Event, bool) {
+	uid := c.GetUint64("userID")
+	lastID := lastEventID(c)
+	if lastID != "" && !taskevents.ValidID(lastID) {
+		problem.Abort(c, 400, "invalid last event id")
+		return nil, nil, false
+	}
+
+	sub := hub.Subscribe(uid)
+	if lastID == "" {
+		return sub, nil, true
+	}
+	backlog, err := hub.Since(c, uid, lastID)
+	if err != nil {
+		sub.Close()
+		log.ErrorContext(c, "Failed to load missed task events", sl.Err(err))
+		problem.Abort(c, 503, "failed to load missed events")
+		return nil, nil, false
+	}
+	return sub, backlog, true
+}
+
+// resumeFloor is the newest event a resumed client has already got, from
+// Last-Event-ID or the replayed backlog. Live events up to it are
+// duplicates. Live events may arrive out of ID order, since events are
+// stored and announced in separate steps, so only this fixed floor is used
+// for deduplication and never the last delivered ID.
+func resumeFloor(lastID string, backlog []taskevents.Event) string {
+	if len(backlog) > 0 {
+		return backlog[len(backlog)-1].ID
+	}
+	return lastID
+}
+
+func lastEventID(c *gin.Context) string {
+	if id := c.GetHeader("Last-Event-ID"); id != "" {
+		return id
+	}
+	return c.Query("last_event_id")
+}
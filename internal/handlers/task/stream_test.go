@@ -0,0 +1,273 @@
+package task
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Citadelas/api-gateway/internal/lib/revocation"
+	"github.com/Citadelas/api-gateway/internal/lib/taskevents"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+const streamUser = 5
+
+// streamServer serves the task event streams of streamUser, authenticated
+// with the session the test passes.
+func streamServer(t *testing.T, client *redis.Client, session revocation.Session) (*httptest.Server, *revocation.Store) {
+	t.Helper()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	hub := taskevents.NewHub(log, client, 16)
+	hub.Start(ctx)
+	denylist := revocation.New(client, time.Hour)
+
+	const heartbeat = 20 * time.Millisecond
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("userID", session.UserID)
+		c.Set("session", session)
+		c.Next()
+	})
+	router.GET("/stream", StreamTasksHandler(log, hub, denylist, heartbeat))
+	router.GET("/ws", TaskSocketHandler(log, hub, denylist, heartbeat))
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return srv, denylist
+}
+
+func TestStreamSessionEnd(t *testing.T) {
+	issued := time.Now().Add(-time.Minute).Unix()
+	revoke := func(t *testing.T, denylist *revocation.Store) {
+		if err := denylist.RevokeUser(context.Background(), streamUser, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name    string
+		session revocation.Session
+		revoke  func(t *testing.T, denylist *revocation.Store)
+		// wantEnd is whether the server ends the stream, and wantCode the
+		// WebSocket close code it does so with.
+		wantEnd  bool
+		wantCode int
+	}{
+		{
+			name:    "valid token keeps the stream",
+			session: revocation.Session{UserID: streamUser, Key: "jti:a", IssuedAt: issued, ExpiresAt: time.Now().Add(time.Hour).Unix()},
+		},
+		{
+			name:     "expired token ends the stream",
+			session:  revocation.Session{UserID: streamUser, Key: "jti:a", IssuedAt: issued, ExpiresAt: time.Now().Add(time.Second).Unix()},
+			wantEnd:  true,
+			wantCode: websocket.ClosePolicyViolation,
+		},
+		{
+			name:     "revoked token ends the stream",
+			session:  revocation.Session{UserID: streamUser, Key: "jti:a", IssuedAt: issued},
+			revoke:   revoke,
+			wantEnd:  true,
+			wantCode: websocket.ClosePolicyViolation,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" (SSE)", func(t *testing.T) {
+			srv, denylist := streamServer(t, newTestRedis(t), tt.session)
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/stream", nil)
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if tt.revoke != nil {
+				tt.revoke(t, denylist)
+			}
+
+			ended := make(chan error, 1)
+			go func() {
+				_, err := io.Copy(io.Discard, resp.Body)
+				ended <- err
+			}()
+			wait := 3 * time.Second
+			if !tt.wantEnd {
+				wait = 200 * time.Millisecond
+			}
+			select {
+			case err := <-ended:
+				if !tt.wantEnd || err != nil {
+					t.Fatalf("stream ended (err %v), want wantEnd = %v", err, tt.wantEnd)
+				}
+			case <-time.After(wait):
+				if tt.wantEnd {
+					t.Fatal("stream did not end")
+				}
+			}
+		})
+		t.Run(tt.name+" (WebSocket)", func(t *testing.T) {
+			srv, denylist := streamServer(t, newTestRedis(t), tt.session)
+			conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if tt.revoke != nil {
+				tt.revoke(t, denylist)
+			}
+
+			wait := 3 * time.Second
+			if !tt.wantEnd {
+				wait = 200 * time.Millisecond
+			}
+			_ = conn.SetReadDeadline(time.Now().Add(wait))
+			_, _, err = conn.ReadMessage()
+			var closeErr *websocket.CloseError
+			switch {
+			case errors.As(err, &closeErr):
+				if closeErr.Code != tt.wantCode {
+					t.Fatalf("close code = %d, want %d", closeErr.Code, tt.wantCode)
+				}
+			case tt.wantEnd:
+				t.Fatalf("socket was not closed: %v", err)
+			}
+		})
+	}
+}
+
+// readEvents reads SSE events until n arrived and returns "<event> <id>" of
+// each.
+func readEvents(t *testing.T, body io.Reader, n int) []string {
+	t.Helper()
+	var got []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var id string
+		scanner := bufio.NewScanner(body)
+		for len(got) < n && scanner.Scan() {
+			line := scanner.Text()
+			if v, ok := strings.CutPrefix(line, "id: "); ok {
+				id = v
+			}
+			if v, ok := strings.CutPrefix(line, "event: "); ok {
+				got = append(got, v+" "+id)
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("got events %v, want %d", got, n)
+	}
+	return got
+}
+
+func TestStreamResume(t *testing.T) {
+	ctx := context.Background()
+	session := revocation.Session{UserID: streamUser, Key: "jti:a"}
+	tests := []struct {
+		name string
+		// trim is how many of the events 0 to 2, published before the client
+		// connects, the stream keeps; 0 keeps all.
+		trim int64
+		// lastID is the index of the Last-Event-ID event, -1 for none and
+		// -2 for one older than every event.
+		lastID int
+		// want are the types and indexes of the events the client gets,
+		// index 3 is the event published after the client connected.
+		want []string
+	}{
+		{name: "no Last-Event-ID", lastID: -1, want: []string{"task.created 3"}},
+		{name: "missed events then live", lastID: 0, want: []string{"task.created 1", "task.created 2", "task.created 3"}},
+		{name: "up to date", lastID: 2, want: []string{"task.created 3"}},
+		{name: "kept events resume", trim: 2, lastID: 1, want: []string{"task.created 2", "task.created 3"}},
+		{name: "trimmed events reset", trim: 2, lastID: 0, want: []string{"stream.reset 2", "task.created 3"}},
+		{name: "older than every event resets", lastID: -2, want: []string{"stream.reset 2", "task.created 3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestRedis(t)
+			srv, _ := streamServer(t, client, session)
+			const key = "tasks:events:user:5"
+			publisher := taskevents.NewPublisher(client, 100)
+			for id := uint64(1); id <= 3; id++ {
+				if err := publisher.Publish(ctx, streamUser, taskevents.Event{Type: taskevents.TypeCreated, TaskID: id}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			entries, err := client.XRange(ctx, key, "-", "+").Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, e := range entries {
+				ids = append(ids, e.ID)
+			}
+			if tt.trim > 0 {
+				if err := client.XTrimMaxLen(ctx, key, tt.trim).Err(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/stream", nil)
+			switch tt.lastID {
+			case -1:
+			case -2:
+				req.Header.Set("Last-Event-ID", "0-1")
+			default:
+				req.Header.Set("Last-Event-ID", ids[tt.lastID])
+			}
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if tt.lastID != -1 {
+				// The newest stored event is announced again, as when it
+				// is stored before and announced after the backlog was
+				// loaded.
+				dup, _ := json.Marshal(map[string]any{
+					"user_id": streamUser,
+					"event":   map[string]any{"id": ids[2], "type": taskevents.TypeCreated, "task_id": 3},
+				})
+				if err := client.Publish(ctx, "tasks:events", dup).Err(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := publisher.Publish(ctx, streamUser, taskevents.Event{Type: taskevents.TypeCreated, TaskID: 4}); err != nil {
+				t.Fatal(err)
+			}
+			live, err := client.XRevRangeN(ctx, key, "+", "-", 1).Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, live[0].ID)
+
+			want := make([]string, 0, len(tt.want))
+			for _, w := range tt.want {
+				typ, idx, _ := strings.Cut(w, " ")
+				i := int(idx[0] - '0')
+				want = append(want, typ+" "+ids[i])
+			}
+			if got := readEvents(t, resp.Body, len(want)); !slices.Equal(got, want) {
+				t.Fatalf("events = %v, want %v", got, want)
+			}
+		})
+	}
+}
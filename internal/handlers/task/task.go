@@ -2,79 +2,113 @@ package task
 
 import (
 	"github.com/Citadelas/api-gateway/internal/helpers/grpc"
+	"github.com/Citadelas/api-gateway/internal/helpers/problem"
+	"github.com/Citadelas/api-gateway/internal/helpers/validation"
+	"github.com/Citadelas/api-gateway/internal/lib/lock"
 	"github.com/Citadelas/api-gateway/internal/lib/logger/sl"
+	"github.com/Citadelas/api-gateway/internal/lib/requestid"
+	"github.com/Citadelas/api-gateway/internal/lib/taskenum"
 	taskv1 "github.com/Citadelas/protos/golang/task"
 	"github.com/gin-gonic/gin"
-	"google.golang.org/protobuf/types/known/timestamppb"
 	"log/slog"
 	"strconv"
 	"time"
 )
 
+// Task is the public representation of a task, used for request bodies and
+// as the base of response DTOs. Id, UserId, Status and CreatedAt are
+// ignored in requests.
 type Task struct {
 	Id          uint64    `json:"id,omitempty"`
 	UserId      uint64    `json:"user_id"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Priority    string    `json:"priority"`
-	Status      string    `json:"status,omitempty"`
+	Title       string    `json:"title" binding:"required,max=255"`
+	Description string    `json:"description" binding:"max=4096"`
+	Priority    string    `json:"priority" binding:"omitempty,task_priority"`
+	Status      string    `json:"status,omitempty" binding:"omitempty,task_status"`
 	CreatedAt   time.Time `json:"created_at"`
-	DueDate     time.Time `json:"due_date"`
+	// DueDate is null when the task has no due date.
+	DueDate *time.Time `json:"due_date" binding:"omitempty,future"`
+}
+
+// priority returns the validated priority of t, low when omitted.
+func (t Task) priority() taskv1.TaskPriority {
+	p, err := taskenum.ParsePriority(t.Priority)
+	if err != nil {
+		return taskv1.TaskPriority_LOW
+	}
+	return p
 }
 
 func CreateTaskHandler(log *slog.Logger, client taskv1.TaskServiceClient) gin.HandlerFunc {
 	const op = "handlers.task.Create"
 	log = log.With("op", op)
 	return func(c *gin.Context) {
+		log := log.With(slog.String("request_id", requestid.FromContext(c)))
 		var req Task
 		uid, _ := c.Get("userID")
-		c.ShouldBind(&req)
+		if err := c.ShouldBindJSON(&req); err != nil {
+			log.InfoContext(c, "Invalid request", sl.Err(err))
+			problem.Write(c, validation.Problem(err))
+			return
+		}
 		grpcReq := taskv1.CreateTaskRequest{
 			UserId:      uid.(uint64),
 			Title:       req.Title,
 			Description: req.Description,
-			Priority:    taskv1.TaskPriority(taskv1.TaskPriority_value[req.Priority]),
-			DueDate:     timestamppb.New(req.DueDate),
+			Priority:    req.priority(),
+			DueDate:     dueDateToProto(req.DueDate),
 		}
 		resp, err := client.CreateTask(c, &grpcReq)
 		if err != nil {
-			log.Error("Error making grpc create task request", sl.Err(err))
+			log.ErrorContext(c, "Error making grpc create task request", sl.Err(err))
 			grpc.HandleGRPCError(c, err)
 			return
 		}
-		log.Info("Task created successfully")
-		c.JSON(200, resp)
+		log.InfoContext(c, "Task created successfully")
+		writeTask(c, 200, resp.GetTask())
 	}
 }
 
-func UpdateTaskHandler(log *slog.Logger, client taskv1.TaskServiceClient) gin.HandlerFunc {
+// UpdateTaskHandler replaces a task. With If-Match the update is applied
+// only if the task still has that ETag, otherwise it fails with 412.
+func UpdateTaskHandler(log *slog.Logger, client taskv1.TaskServiceClient, locks *lock.Locker) gin.HandlerFunc {
 	const op = "handlers.task.Update"
 	log = log.With("op", op)
 	return func(c *gin.Context) {
+		log := log.With(slog.String("request_id", requestid.FromContext(c)))
 		var req Task
 		uid, _ := c.Get("userID")
 		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
 		if err != nil {
-			c.JSON(400, gin.H{"error": "invalid id"})
+			problem.Abort(c, 400, "invalid id")
+			return
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			log.InfoContext(c, "Invalid request", sl.Err(err))
+			problem.Write(c, validation.Problem(err))
 			return
 		}
-		c.ShouldBind(&req)
 		grpcReq := taskv1.UpdateTaskRequest{
 			Id:          id,
 			UserId:      uid.(uint64),
 			Title:       req.Title,
 			Description: req.Description,
-			Priority:    taskv1.TaskPriority(taskv1.TaskPriority_value[req.Priority]),
-			DueDate:     timestamppb.New(req.DueDate),
+			Priority:    req.priority(),
+			DueDate:     dueDateToProto(req.DueDate),
 		}
+		unlock, ok := checkIfMatch(c, log, client, locks, id, uid.(uint64))
+		if !ok {
+			return
+		}
+		defer unlock()
 		resp, err := client.UpdateTask(c, &grpcReq)
 		if err != nil {
-			log.Error("Error making grpc update task request", sl.Err(err))
+			log.ErrorContext(c, "Error making grpc update task request", sl.Err(err))
 			grpc.HandleGRPCError(c, err)
 			return
 		}
-		log.Info("Task updated successfully")
-		c.JSON(200, resp)
+		log.InfoContext(c, "Task updated successfully")
+		writeTask(c, 200, resp.GetTask())
 	}
 }
 
@@ -82,10 +116,11 @@ func GetTaskHandler(log *slog.Logger, client taskv1.TaskServiceClient) gin.Handl
 	const op = "handlers.task.Get"
 	log = log.With("op", op)
 	return func(c *gin.Context) {
+		log := log.With(slog.String("request_id", requestid.FromContext(c)))
 		uid, _ := c.Get("userID")
 		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
 		if err != nil {
-			c.JSON(400, gin.H{"error": "invalid id"})
+			problem.Abort(c, 400, "invalid id")
 			return
 		}
 		grpcReq := taskv1.GetTaskRequest{
@@ -94,71 +129,88 @@ func GetTaskHandler(log *slog.Logger, client taskv1.TaskServiceClient) gin.Handl
 		}
 		resp, err := client.GetTask(c, &grpcReq)
 		if err != nil {
-			log.Error("Error making grpc get task request", sl.Err(err))
+			log.ErrorContext(c, "Error making grpc get task request", sl.Err(err))
 			grpc.HandleGRPCError(c, err)
 			return
 		}
-		log.Info("Task get successfully")
-		c.JSON(200, resp)
+		log.InfoContext(c, "Task get successfully")
+		writeTask(c, 200, resp.GetTask())
 	}
 }
 
-func DeleteTaskHandler(log *slog.Logger, client taskv1.TaskServiceClient) gin.HandlerFunc {
+// DeleteTaskHandler deletes a task, honoring If-Match like UpdateTaskHandler.
+func DeleteTaskHandler(log *slog.Logger, client taskv1.TaskServiceClient, locks *lock.Locker) gin.HandlerFunc {
 	const op = "handlers.task.Delete"
 	log = log.With("op", op)
 	return func(c *gin.Context) {
+		log := log.With(slog.String("request_id", requestid.FromContext(c)))
 		uid, _ := c.Get("userID")
 		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
 		if err != nil {
-			c.JSON(400, gin.H{"error": "invalid id"})
+			problem.Abort(c, 400, "invalid id")
 			return
 		}
 		grpcReq := taskv1.DeleteTaskRequest{
 			Id:     id,
 			UserId: uid.(uint64),
 		}
-		resp, err := client.DeleteTask(c, &grpcReq)
+		unlock, ok := checkIfMatch(c, log, client, locks, id, uid.(uint64))
+		if !ok {
+			return
+		}
+		defer unlock()
+		_, err = client.DeleteTask(c, &grpcReq)
 		if err != nil {
-			log.Error("Error making grpc delete task request", sl.Err(err))
+			log.ErrorContext(c, "Error making grpc delete task request", sl.Err(err))
 			grpc.HandleGRPCError(c, err)
 			return
 		}
-		log.Info("Task deleted successfully")
-		c.JSON(200, resp)
+		log.InfoContext(c, "Task deleted successfully")
+		c.Status(204)
 	}
 }
 
 type UpdateStatusReq struct {
-	Status string `json:"status"`
+	Status string `json:"status" binding:"required,task_status"`
 }
 
-func UpdateStatusHandler(log *slog.Logger, client taskv1.TaskServiceClient) gin.HandlerFunc {
+// UpdateStatusHandler changes the task status, honoring If-Match like
+// UpdateTaskHandler.
+func UpdateStatusHandler(log *slog.Logger, client taskv1.TaskServiceClient, locks *lock.Locker) gin.HandlerFunc {
 	const op = "handlers.task.UpdateStatus"
 	log = log.With("op", op)
 	return func(c *gin.Context) {
+		log := log.With(slog.String("request_id", requestid.FromContext(c)))
 		var req UpdateStatusReq
-		if err := c.ShouldBind(&req); err != nil {
-			c.JSON(400, gin.H{"error": err.Error()})
+		if err := c.ShouldBindJSON(&req); err != nil {
+			log.InfoContext(c, "Invalid request", sl.Err(err))
+			problem.Write(c, validation.Problem(err))
 			return
 		}
 		uid, _ := c.Get("userID")
 		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
 		if err != nil {
-			c.JSON(400, gin.H{"error": "invalid id"})
+			problem.Abort(c, 400, "invalid id")
 			return
 		}
+		status, _ := taskenum.ParseStatus(req.Status)
 		grpcReq := taskv1.UpdateStatusRequest{
 			Id:     id,
 			UserId: uid.(uint64),
-			Status: taskv1.TaskStatus(taskv1.TaskStatus_value[req.Status]),
+			Status: status,
+		}
+		unlock, ok := checkIfMatch(c, log, client, locks, id, uid.(uint64))
+		if !ok {
+			return
 		}
+		defer unlock()
 		resp, err := client.UpdateStatus(c, &grpcReq)
 		if err != nil {
-			log.Error("Error making grpc update task status request", sl.Err(err))
+			log.ErrorContext(c, "Error making grpc update task status request", sl.Err(err))
 			grpc.HandleGRPCError(c, err)
 			return
 		}
-		log.Info("Task status updated successfully")
-		c.JSON(200, resp)
+		log.InfoContext(c, "Task status updated successfully")
+		writeTask(c, 200, resp.GetTask())
 	}
 }
@@ -1,28 +1,166 @@
 package grpc
 
 import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Citadelas/api-gateway/internal/helpers/problem"
 	"github.com/gin-gonic/gin"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	"net/http"
 )
 
+// StatusClientClosedRequest is the nginx convention for requests the client
+// gave up on before the response was ready.
+const StatusClientClosedRequest = 499
+
+// optionsKey is the gin context key of the Options of a request.
+const optionsKey = "grpcErrorOptions"
+
+// Options control how gRPC errors are turned into problems.
+type Options struct {
+	// HideInternal replaces the backend message and details of every 5xx
+	// error with the generic status text, so internals such as addresses
+	// from transport errors do not leak to clients. Retry-After is kept.
+	HideInternal bool
+}
+
+// WithOptions is a middleware setting the options used by HandleGRPCError
+// and Problem for the requests it runs for.
+func WithOptions(opts Options) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(optionsKey, opts)
+		c.Next()
+	}
+}
+
+func optionsOf(c *gin.Context) Options {
+	opts, _ := c.Value(optionsKey).(Options)
+	return opts
+}
+
+// HandleGRPCError replies with a problem built from the gRPC status of err,
+// including its error details.
 func HandleGRPCError(c *gin.Context, err error) {
+	p, locale := newProblem(err, c.GetHeader("Accept-Language"), optionsOf(c))
+	if locale != "" {
+		c.Header("Content-Language", locale)
+	}
+	if p.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(p.RetryAfter))
+	}
+	problem.Write(c, p)
+}
+
+// Problem builds the problem of err without writing it, for responses that
+// embed several errors.
+func Problem(c *gin.Context, err error) *problem.Problem {
+	p, _ := newProblem(err, c.GetHeader("Accept-Language"), optionsOf(c))
+	return p
+}
+
+// newProblem converts err and returns the locale of its detail, if the
+// backend sent a localized message.
+func newProblem(err error, acceptLanguage string, opts Options) (*problem.Problem, string) {
 	st := status.Convert(err)
-	httpStatus := GRPCToHTTPStatus(st.Code())
+	details := st.Details()
+
+	p := problem.New(httpStatus(st.Code(), details), st.Message())
+	p.Code = st.Code().String()
+	msg, locale, ok := localizedMessage(details, acceptLanguage)
+	if ok {
+		p.Detail = msg
+	}
+	for _, d := range details {
+		switch d := d.(type) {
+		case *errdetails.BadRequest:
+			for _, v := range d.GetFieldViolations() {
+				p.Errors = append(p.Errors, problem.FieldError{
+					Field:   v.GetField(),
+					Message: v.GetDescription(),
+				})
+			}
+		case *errdetails.PreconditionFailure:
+			for _, v := range d.GetViolations() {
+				p.Errors = append(p.Errors, problem.FieldError{
+					Field:   v.GetSubject(),
+					Message: v.GetDescription(),
+				})
+			}
+		case *errdetails.QuotaFailure:
+			for _, v := range d.GetViolations() {
+				p.Errors = append(p.Errors, problem.FieldError{
+					Field:   v.GetSubject(),
+					Message: v.GetDescription(),
+				})
+			}
+		case *errdetails.RetryInfo:
+			if delay := d.GetRetryDelay(); delay != nil {
+				p.RetryAfter = int(math.Ceil(delay.AsDuration().Seconds()))
+			}
+		case *errdetails.ErrorInfo:
+			p.Reason = d.GetReason()
+			p.Domain = d.GetDomain()
+			p.Metadata = d.GetMetadata()
+		}
+	}
+
+	if opts.HideInternal && p.Status >= 500 {
+		p.Detail = strings.ToLower(http.StatusText(p.Status))
+		p.Errors, p.Metadata = nil, nil
+		p.Reason, p.Domain = "", ""
+		locale = ""
+	}
+	return p, locale
+}
 
-	c.JSON(httpStatus, gin.H{
-		"error": st.Message(),
-		"code":  st.Code().String(),
-	})
+// httpStatus maps the gRPC code to an HTTP status. FailedPrecondition is a
+// conflict with the resource state when the backend describes the failed
+// preconditions, and a bad request otherwise.
+func httpStatus(code codes.Code, details []any) int {
+	if code == codes.FailedPrecondition {
+		for _, d := range details {
+			if _, ok := d.(*errdetails.PreconditionFailure); ok {
+				return http.StatusConflict
+			}
+		}
+	}
+	return GRPCToHTTPStatus(code)
+}
+
+// localizedMessage picks the LocalizedMessage matching the first language
+// of Accept-Language, or the first one if none matches.
+func localizedMessage(details []any, acceptLanguage string) (msg, locale string, ok bool) {
+	lang, _, _ := strings.Cut(acceptLanguage, ",")
+	lang, _, _ = strings.Cut(lang, ";")
+	lang = strings.TrimSpace(lang)
+	for _, d := range details {
+		lm, isLM := d.(*errdetails.LocalizedMessage)
+		if !isLM {
+			continue
+		}
+		if !ok || (lang != "" && strings.HasPrefix(strings.ToLower(lm.GetLocale()), strings.ToLower(lang))) {
+			msg, locale, ok = lm.GetMessage(), lm.GetLocale(), true
+		}
+	}
+	return msg, locale, ok
 }
 
 func GRPCToHTTPStatus(grpcCode codes.Code) int {
 	switch grpcCode {
 	case codes.OK:
 		return http.StatusOK
+	case codes.Canceled:
+		return StatusClientClosedRequest
+	case codes.Unknown:
+		return http.StatusInternalServerError
 	case codes.InvalidArgument:
 		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
 	case codes.NotFound:
 		return http.StatusNotFound
 	case codes.AlreadyExists:
@@ -0,0 +1,89 @@
+package grpc
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestNewProblemHideInternal(t *testing.T) {
+	withDetails := func(code codes.Code, msg string) error {
+		st, err := status.New(code, msg).WithDetails(
+			&errdetails.RetryInfo{RetryDelay: durationpb.New(1500 * time.Millisecond)},
+			&errdetails.ErrorInfo{Reason: "BACKEND_DOWN", Domain: "task", Metadata: map[string]string{"host": "10.0.0.7"}},
+			&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: "title", Description: "too long"}}},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st.Err()
+	}
+
+	tests := []struct {
+		name       string
+		err        error
+		hide       bool
+		wantStatus int
+		wantDetail string
+		wantErrors bool
+	}{
+		{
+			name:       "unavailable is hidden",
+			err:        withDetails(codes.Unavailable, "connection error: dial tcp 10.0.0.7:44045: connect: connection refused"),
+			hide:       true,
+			wantStatus: http.StatusServiceUnavailable,
+			wantDetail: "service unavailable",
+		},
+		{
+			name:       "deadline is hidden",
+			err:        withDetails(codes.DeadlineExceeded, "context deadline exceeded at 10.0.0.7"),
+			hide:       true,
+			wantStatus: http.StatusGatewayTimeout,
+			wantDetail: "gateway timeout",
+		},
+		{
+			name:       "internal is hidden",
+			err:        withDetails(codes.Internal, "pq: relation tasks does not exist"),
+			hide:       true,
+			wantStatus: http.StatusInternalServerError,
+			wantDetail: "internal server error",
+		},
+		{
+			name:       "client errors are kept",
+			err:        withDetails(codes.InvalidArgument, "invalid title"),
+			hide:       true,
+			wantStatus: http.StatusBadRequest,
+			wantDetail: "invalid title",
+			wantErrors: true,
+		},
+		{
+			name:       "nothing is hidden outside prod",
+			err:        withDetails(codes.Unavailable, "connection error"),
+			wantStatus: http.StatusServiceUnavailable,
+			wantDetail: "connection error",
+			wantErrors: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := newProblem(tt.err, "", Options{HideInternal: tt.hide})
+			if p.Status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", p.Status, tt.wantStatus)
+			}
+			if p.Detail != tt.wantDetail {
+				t.Errorf("detail = %q, want %q", p.Detail, tt.wantDetail)
+			}
+			if got := len(p.Errors) > 0 || p.Metadata != nil || p.Reason != ""; got != tt.wantErrors {
+				t.Errorf("has details = %v, want %v", got, tt.wantErrors)
+			}
+			if p.RetryAfter != 2 {
+				t.Errorf("retry after = %d, want 2", p.RetryAfter)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package problem
+
+import (
+	"net/http"
+
+	"github.com/Citadelas/api-gateway/internal/lib/requestid"
+	"github.com/gin-gonic/gin"
+)
+
+// ContentType is the media type of error responses (RFC 7807).
+const ContentType = "application/problem+json"
+
+// Problem is the error body of every gateway response with a 4xx or 5xx
+// status.
+type Problem struct {
+	// Type is a URI identifying the problem type, "about:blank" when the
+	// HTTP status is descriptive enough.
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Instance is the request path.
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	// Code is the gRPC status code for errors returned by backend services.
+	Code string `json:"code,omitempty"`
+	// Reason, Domain and Metadata come from google.rpc.ErrorInfo.
+	Reason   string            `json:"reason,omitempty"`
+	Domain   string            `json:"domain,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// RetryAfter is the delay in seconds suggested by the backend.
+	RetryAfter int          `json:"retry_after,omitempty"`
+	Errors     []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes an invalid request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// New creates a problem of the given HTTP status.
+func New(status int, detail string) *Problem {
+	title := http.StatusText(status)
+	if status == 499 {
+		title = "Client Closed Request"
+	}
+	return &Problem{
+		Type:   "about:blank",
+		Title:  title,
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// WithErrors attaches field errors to the problem.
+func (p *Problem) WithErrors(errs ...FieldError) *Problem {
+	p.Errors = append(p.Errors, errs...)
+	return p
+}
+
+// Write sends p and aborts the handler chain.
+func Write(c *gin.Context, p *Problem) {
+	if p.Instance == "" {
+		p.Instance = c.Request.URL.Path
+	}
+	if p.RequestID == "" {
+		p.RequestID = requestid.FromContext(c.Request.Context())
+	}
+	c.Header("Content-Type", ContentType)
+	c.AbortWithStatusJSON(p.Status, p)
+}
+
+// Abort sends a problem of the given status and aborts the handler chain.
+func Abort(c *gin.Context, status int, detail string) {
+	Write(c, New(status, detail))
+}
+
+// NoRoute replies with 404 to unknown routes.
+func NoRoute(c *gin.Context) {
+	Abort(c, 404, "route not found")
+}
+
+// NoMethod replies with 405 to unsupported methods of known routes.
+func NoMethod(c *gin.Context) {
+	Abort(c, 405, "method not allowed")
+}
+
+// Recovery replies with 500 after a handler panic.
+func Recovery(c *gin.Context, _ any) {
+	Abort(c, 500, "internal error")
+}
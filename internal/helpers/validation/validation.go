@@ -0,0 +1,142 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Citadelas/api-gateway/internal/helpers/problem"
+	"github.com/Citadelas/api-gateway/internal/lib/taskenum"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// Register adds the custom validators to the gin binding engine and makes
+// field errors use JSON field names. It must be called once at startup,
+// before any request is bound.
+func Register() error {
+	const op = "validation.Register"
+
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return fmt.Errorf("%s: unexpected validator engine %T", op, binding.Validator.Engine())
+	}
+	v.RegisterTagNameFunc(jsonName)
+
+	validators := map[string]validator.Func{
+		"task_priority": taskPriority,
+		"task_status":   taskStatus,
+		"future":        future,
+	}
+	for tag, fn := range validators {
+		if err := v.RegisterValidation(tag, fn); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	return nil
+}
+
+// jsonName names fields after their json tag, or form tag for query
+// parameters.
+func jsonName(f reflect.StructField) string {
+	for _, tag := range []string{"json", "form"} {
+		name, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return f.Name
+}
+
+func taskPriority(fl validator.FieldLevel) bool {
+	_, err := taskenum.ParsePriority(fl.Field().String())
+	return err == nil
+}
+
+func taskStatus(fl validator.FieldLevel) bool {
+	_, err := taskenum.ParseStatus(fl.Field().String())
+	return err == nil
+}
+
+func future(fl validator.FieldLevel) bool {
+	t, ok := fl.Field().Interface().(time.Time)
+	return ok && t.After(time.Now())
+}
+
+// Problem converts a binding error into a 400 problem with field errors.
+func Problem(err error) *problem.Problem {
+	p := problem.New(400, "invalid request")
+
+	var verrs validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	var timeErr *time.ParseError
+	switch {
+	case errors.As(err, &verrs):
+		for _, fe := range verrs {
+			p.Errors = append(p.Errors, problem.FieldError{
+				Field:   fieldPath(fe),
+				Message: message(fe),
+			})
+		}
+	case errors.As(err, &typeErr):
+		p.Errors = append(p.Errors, problem.FieldError{
+			Field:   typeErr.Field,
+			Message: "must be " + typeErr.Type.String(),
+		})
+	case errors.As(err, &timeErr):
+		p.Detail = "invalid time, expected RFC 3339"
+	case errors.Is(err, io.EOF):
+		p.Detail = "request body is empty"
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		p.Detail = "malformed JSON"
+	default:
+		p.Detail = err.Error()
+	}
+	return p
+}
+
+// fieldPath drops the struct name from the namespace, "Task.title"
+// becomes "title".
+func fieldPath(fe validator.FieldError) string {
+	_, path, ok := strings.Cut(fe.Namespace(), ".")
+	if !ok {
+		return fe.Field()
+	}
+	return path
+}
+
+func message(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email"
+	case "min":
+		if fe.Param() == "1" {
+			return "must not be empty"
+		}
+		return "must be at least " + fe.Param() + " characters long"
+	case "max":
+		return "must be at most " + fe.Param() + " characters long"
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "gt":
+		return "must be greater than " + fe.Param()
+	case "task_priority":
+		return "must be one of " + strings.Join(taskenum.Priorities, ", ")
+	case "task_status":
+		return "must be one of " + strings.Join(taskenum.Statuses, ", ")
+	case "future":
+		return "must be in the future"
+	default:
+		return "failed on " + fe.Tag() + " validation"
+	}
+}
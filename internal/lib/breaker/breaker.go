@@ -0,0 +1,166 @@
+package breaker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	prometheus2 "github.com/Citadelas/api-gateway/internal/app/prometheus"
+	"github.com/Citadelas/api-gateway/internal/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type State int
+
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Breaker is a closed/open/half-open circuit breaker for one backend.
+// In the closed state it counts calls in fixed windows and opens when the
+// failure ratio is reached. After the cool-down it lets a few probe calls
+// through (half-open) and closes once they all succeed.
+type Breaker struct {
+	name string
+	cfg  config.Breaker
+
+	mu          sync.Mutex
+	state       State
+	generation  uint64
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probes      int
+	successes   int
+}
+
+func New(name string, cfg config.Breaker) *Breaker {
+	cfg.HalfOpenRequests = max(cfg.HalfOpenRequests, 1)
+	b := &Breaker{
+		name:        name,
+		cfg:         cfg,
+		windowStart: time.Now(),
+	}
+	prometheus2.CircuitBreakerState.WithLabelValues(name).Set(float64(StateClosed))
+	return b
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// UnaryClientInterceptor fails calls fast with codes.Unavailable while the
+// circuit is open. It must wrap the retry interceptor, so a retried call
+// counts once.
+func (b *Breaker) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		generation, ok := b.allow()
+		if !ok {
+			return status.Errorf(codes.Unavailable, "circuit breaker is open for %s", b.name)
+		}
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		b.done(generation, isFailure(err))
+		return err
+	}
+}
+
+func (b *Breaker) allow() (uint64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	switch b.state {
+	case StateClosed:
+		if now.Sub(b.windowStart) > b.cfg.Window {
+			b.windowStart, b.requests, b.failures = now, 0, 0
+		}
+		return b.generation, true
+	case StateOpen:
+		if now.Sub(b.openedAt) < b.cfg.CoolDown {
+			return 0, false
+		}
+		b.setState(StateHalfOpen)
+	}
+
+	if b.probes >= b.cfg.HalfOpenRequests {
+		return 0, false
+	}
+	b.probes++
+	return b.generation, true
+}
+
+func (b *Breaker) done(generation uint64, failure bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// The call was admitted before the last state change.
+	if generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case StateClosed:
+		b.requests++
+		if failure {
+			b.failures++
+		}
+		if b.requests >= b.cfg.MinRequests && float64(b.failures)/float64(b.requests) >= b.cfg.FailureRatio {
+			b.setState(StateOpen)
+		}
+	case StateHalfOpen:
+		if failure {
+			b.setState(StateOpen)
+			return
+		}
+		b.successes++
+		if b.successes >= b.cfg.HalfOpenRequests {
+			b.setState(StateClosed)
+		}
+	}
+}
+
+// setState must be called with mu held.
+func (b *Breaker) setState(state State) {
+	now := time.Now()
+	b.state = state
+	b.generation++
+	b.probes, b.successes = 0, 0
+	switch state {
+	case StateOpen:
+		b.openedAt = now
+	case StateClosed:
+		b.windowStart, b.requests, b.failures = now, 0, 0
+	}
+	prometheus2.CircuitBreakerState.WithLabelValues(b.name).Set(float64(state))
+}
+
+// isFailure reports whether the error says the backend is unhealthy, as
+// opposed to errors caused by the request itself.
+func isFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown, codes.DataLoss:
+		return true
+	default:
+		return false
+	}
+}
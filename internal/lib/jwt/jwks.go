@@ -0,0 +1,167 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Citadelas/api-gateway/internal/lib/logger/sl"
+)
+
+const maxJWKSSize = 1 << 20
+
+// JWKSProvider is a KeyProvider over a JWKS document fetched from a URL or
+// read from a file. Keys are cached by kid and refreshed in the background;
+// an unknown kid triggers a refetch at most once per minRefetch, so signing
+// keys can be rotated without restarting the gateway.
+type JWKSProvider struct {
+	log        *slog.Logger
+	source     string
+	client     *http.Client
+	refresh    time.Duration
+	minRefetch time.Duration
+
+	mu        sync.RWMutex
+	keys      map[string]crypto.PublicKey
+	lastFetch time.Time
+
+	// fetchMu serializes fetches so a burst of unknown kids fetches once.
+	fetchMu sync.Mutex
+}
+
+// NewJWKSProvider creates a provider for source, which is either an
+// http(s) URL or a file path. A nil client uses a client with a 10s timeout.
+func NewJWKSProvider(log *slog.Logger, source string, client *http.Client, refresh, minRefetch time.Duration) *JWKSProvider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &JWKSProvider{
+		log:        log.With("op", "jwt.JWKSProvider", "source", source),
+		source:     source,
+		client:     client,
+		refresh:    refresh,
+		minRefetch: minRefetch,
+		keys:       make(map[string]crypto.PublicKey),
+	}
+}
+
+// Start fetches the keys and refreshes them every refresh interval until
+// ctx is done. A failed initial fetch is logged, not returned: the keys are
+// fetched again on the first token with an unknown kid.
+func (p *JWKSProvider) Start(ctx context.Context) {
+	if err := p.Refresh(ctx); err != nil {
+		p.log.Error("Failed to fetch JWKS", sl.Err(err))
+	}
+	if p.refresh <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(p.refresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := p.Refresh(ctx); err != nil {
+					p.log.Error("Failed to refresh JWKS", sl.Err(err))
+				}
+			}
+		}
+	}()
+}
+
+func (p *JWKSProvider) PublicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if key, ok := p.lookup(kid); ok {
+		return key, nil
+	}
+
+	p.fetchMu.Lock()
+	defer p.fetchMu.Unlock()
+
+	// Another request may have fetched the key while we waited.
+	if key, ok := p.lookup(kid); ok {
+		return key, nil
+	}
+	p.mu.RLock()
+	recent := time.Since(p.lastFetch) < p.minRefetch
+	p.mu.RUnlock()
+	if recent {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	if err := p.fetch(ctx); err != nil {
+		return nil, fmt.Errorf("refetch jwks: %w", err)
+	}
+	if key, ok := p.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+// Refresh fetches the key set and replaces the cached keys.
+func (p *JWKSProvider) Refresh(ctx context.Context) error {
+	p.fetchMu.Lock()
+	defer p.fetchMu.Unlock()
+	return p.fetch(ctx)
+}
+
+func (p *JWKSProvider) lookup(kid string) (crypto.PublicKey, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// fetch must be called with fetchMu held.
+func (p *JWKSProvider) fetch(ctx context.Context) error {
+	// Count failed attempts too, so a broken source is not hammered.
+	p.mu.Lock()
+	p.lastFetch = time.Now()
+	p.mu.Unlock()
+
+	data, err := p.read(ctx)
+	if err != nil {
+		return err
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	p.log.Debug("JWKS refreshed", slog.Int("keys", len(keys)))
+	return nil
+}
+
+func (p *JWKSProvider) read(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(p.source, "http://") && !strings.HasPrefix(p.source, "https://") {
+		return os.ReadFile(p.source)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+}
@@ -0,0 +1,142 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// jwksServer serves a JWKS document that tests can swap, counting fetches.
+type jwksServer struct {
+	*httptest.Server
+	hits atomic.Int32
+
+	mu   sync.Mutex
+	keys map[string]*ecdsa.PublicKey
+}
+
+func newJWKSServer(t *testing.T) *jwksServer {
+	t.Helper()
+	s := &jwksServer{keys: make(map[string]*ecdsa.PublicKey)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.hits.Add(1)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var set struct {
+			Keys []map[string]string `json:"keys"`
+		}
+		for kid, key := range s.keys {
+			set.Keys = append(set.Keys, map[string]string{
+				"kty": "EC",
+				"crv": "P-256",
+				"kid": kid,
+				"use": "sig",
+				"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+				"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+			})
+		}
+		_ = json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// rotate replaces the served keys with new keys of the given kids.
+func (s *jwksServer) rotate(t *testing.T, kids ...string) map[string]*ecdsa.PublicKey {
+	t.Helper()
+	keys := make(map[string]*ecdsa.PublicKey, len(kids))
+	for _, kid := range kids {
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[kid] = &priv.PublicKey
+	}
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+	return keys
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestJWKSProviderRotation(t *testing.T) {
+	srv := newJWKSServer(t)
+	old := srv.rotate(t, "old")
+	p := NewJWKSProvider(discardLogger(), srv.URL, srv.Client(), 0, 0)
+	ctx := context.Background()
+	if err := p.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+
+	key, err := p.PublicKey(ctx, "old")
+	if err != nil {
+		t.Fatalf("PublicKey(old): %v", err)
+	}
+	if !old["old"].Equal(key) {
+		t.Fatal("PublicKey(old) returned another key")
+	}
+
+	rotated := srv.rotate(t, "new")
+	key, err = p.PublicKey(ctx, "new")
+	if err != nil {
+		t.Fatalf("PublicKey(new) after rotation: %v", err)
+	}
+	if !rotated["new"].Equal(key) {
+		t.Fatal("PublicKey(new) returned another key")
+	}
+	if _, err := p.PublicKey(ctx, "old"); err == nil {
+		t.Fatal("PublicKey(old) succeeded after the key was rotated out")
+	}
+}
+
+func TestJWKSProviderMinRefetch(t *testing.T) {
+	tests := []struct {
+		name       string
+		minRefetch time.Duration
+		wait       time.Duration
+		lookups    int
+		wantHits   int32
+	}{
+		{name: "unknown kids within the interval do not refetch", minRefetch: time.Hour, lookups: 5, wantHits: 1},
+		{name: "unknown kid after the interval refetches once", minRefetch: 20 * time.Millisecond, wait: 40 * time.Millisecond, lookups: 5, wantHits: 2},
+		{name: "no interval refetches every time", minRefetch: 0, lookups: 3, wantHits: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newJWKSServer(t)
+			srv.rotate(t, "known")
+			p := NewJWKSProvider(discardLogger(), srv.URL, srv.Client(), 0, tt.minRefetch)
+			ctx := context.Background()
+			if err := p.Refresh(ctx); err != nil {
+				t.Fatalf("Refresh: %v", err)
+			}
+			time.Sleep(tt.wait)
+
+			for i := 0; i < tt.lookups; i++ {
+				if _, err := p.PublicKey(ctx, "unknown"); err == nil {
+					t.Fatal("PublicKey(unknown) succeeded")
+				}
+			}
+			if _, err := p.PublicKey(ctx, "known"); err != nil {
+				t.Fatalf("PublicKey(known): %v", err)
+			}
+			if got := srv.hits.Load(); got != tt.wantHits {
+				t.Fatalf("fetches = %d, want %d", got, tt.wantHits)
+			}
+		})
+	}
+}
@@ -2,10 +2,14 @@ package jwt
 
 import (
 	"context"
+	"crypto"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/Citadelas/api-gateway/internal/config"
 	ssov1 "github.com/Citadelas/protos/golang/sso"
 	"github.com/golang-jwt/jwt/v5"
 	"google.golang.org/grpc/codes"
@@ -16,9 +20,66 @@ type CustomClaims struct {
 	UserID uint64 `json:"uid"`
 	Email  string `json:"email"`
 	AppID  int32  `json:"app_id"`
+	// Roles and IsAdmin are optional, SSO may not put them into tokens.
+	Roles   []string `json:"roles,omitempty"`
+	IsAdmin bool     `json:"is_admin,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// Validator verifies token signatures locally: HS256 with the secret of the
+// token app, RS256/ES256 with keys from a JWKS provider or a PEM file.
+type Validator struct {
+	parser    *jwt.Parser
+	secrets   map[int32][]byte
+	jwks      KeyProvider
+	pemKey    crypto.PublicKey
+	appIDs    []int32
+	ssoClient ssov1.AuthClient
+}
+
+// NewValidator creates a validator from cfg. jwks may be nil when no JWKS
+// source is configured.
+func NewValidator(cfg config.JWT, ssoClient ssov1.AuthClient, jwks KeyProvider) (*Validator, error) {
+	const op = "jwt.NewValidator"
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"HS256", "RS256", "ES256"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(cfg.Leeway),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+
+	v := &Validator{
+		parser:  jwt.NewParser(opts...),
+		secrets: make(map[int32][]byte, len(cfg.Secrets)),
+		jwks:    jwks,
+		appIDs:  cfg.AppIDs,
+	}
+	for appID, secret := range cfg.Secrets {
+		v.secrets[appID] = []byte(secret)
+	}
+	if cfg.SSOUserCheck {
+		v.ssoClient = ssoClient
+	}
+
+	if cfg.PublicKeyPath != "" {
+		key, err := LoadPublicKeyPEM(cfg.PublicKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		v.pemKey = key
+	}
+	if len(v.secrets) == 0 && v.jwks == nil && v.pemKey == nil {
+		return nil, fmt.Errorf("%s: no verification keys configured", op)
+	}
+	return v, nil
+}
+
 func ExtractToken(authHeader string) string {
 	parts := strings.Split(authHeader, " ")
 	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
@@ -27,48 +88,98 @@ func ExtractToken(authHeader string) string {
 	return parts[1]
 }
 
-func ValidateToken(ctx context.Context, ssoClient ssov1.AuthClient, tokenString string) (uint64, error) {
+// Validate verifies the token signature and claims and returns the claims.
+func (v *Validator) Validate(ctx context.Context, tokenString string) (*CustomClaims, error) {
 	if tokenString == "" {
-		return 0, fmt.Errorf("empty token")
+		return nil, fmt.Errorf("empty token")
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-
-	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, &CustomClaims{})
+	claims := &CustomClaims{}
+	_, err := v.parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
+		return v.key(ctx, token)
+	})
 	if err != nil {
-		return 0, fmt.Errorf("malformed token: %w", err)
+		switch {
+		case errors.Is(err, jwt.ErrTokenExpired):
+			return nil, fmt.Errorf("token expired")
+		case errors.Is(err, jwt.ErrTokenNotValidYet):
+			return nil, fmt.Errorf("token not valid yet")
+		case errors.Is(err, jwt.ErrTokenMalformed):
+			return nil, fmt.Errorf("malformed token: %w", err)
+		default:
+			return nil, fmt.Errorf("invalid token: %w", err)
+		}
 	}
 
-	claims, ok := token.Claims.(*CustomClaims)
-	if !ok {
-		return 0, fmt.Errorf("invalid token claims structure")
+	if claims.UserID == 0 {
+		return nil, fmt.Errorf("invalid token: missing uid")
+	}
+	if len(v.appIDs) > 0 && !slices.Contains(v.appIDs, claims.AppID) {
+		return nil, fmt.Errorf("invalid token: unknown app_id %d", claims.AppID)
 	}
 
-	if claims.ExpiresAt != nil && time.Now().After(claims.ExpiresAt.Time) {
-		return 0, fmt.Errorf("token expired")
+	if v.ssoClient != nil {
+		if err := v.checkUser(ctx, claims.UserID); err != nil {
+			return nil, err
+		}
 	}
 
-	_, err = ssoClient.IsAdmin(ctx, &ssov1.IsAdminRequest{
-		UserId: int64(claims.UserID),
-	})
+	return claims, nil
+}
 
-	if err != nil {
-		grpcErr, ok := status.FromError(err)
-		if ok {
-			switch grpcErr.Code() {
-			case codes.NotFound:
-				return 0, fmt.Errorf("invalid token: user not found")
-			case codes.Unauthenticated:
-				return 0, fmt.Errorf("invalid token: authentication failed")
-			case codes.DeadlineExceeded:
-				return 0, fmt.Errorf("token validation timeout")
-			default:
-				return 0, fmt.Errorf("token validation failed: %w", err)
+func (v *Validator) key(ctx context.Context, token *jwt.Token) (any, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		claims, ok := token.Claims.(*CustomClaims)
+		if !ok {
+			return nil, fmt.Errorf("invalid token claims structure")
+		}
+		secret, ok := v.secrets[claims.AppID]
+		if !ok {
+			return nil, fmt.Errorf("no secret for app_id %d", claims.AppID)
+		}
+		return secret, nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		kid, _ := token.Header["kid"].(string)
+		if v.jwks != nil {
+			key, err := v.jwks.PublicKey(ctx, kid)
+			if err == nil || v.pemKey == nil {
+				return key, err
 			}
 		}
-		return 0, fmt.Errorf("SSO service unavailable: %w", err)
+		if v.pemKey != nil {
+			return v.pemKey, nil
+		}
+		return nil, fmt.Errorf("no public keys configured")
+	default:
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
 	}
+}
 
-	return claims.UserID, nil
+// checkUser asks SSO whether the token user still exists.
+func (v *Validator) checkUser(ctx context.Context, userID uint64) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := v.ssoClient.IsAdmin(ctx, &ssov1.IsAdminRequest{
+		UserId: int64(userID),
+	})
+	if err == nil {
+		return nil
+	}
+
+	grpcErr, ok := status.FromError(err)
+	if ok {
+		switch grpcErr.Code() {
+		case codes.NotFound:
+			return fmt.Errorf("invalid token: user not found")
+		case codes.Unauthenticated:
+			return fmt.Errorf("invalid token: authentication failed")
+		case codes.DeadlineExceeded:
+			return fmt.Errorf("token validation timeout")
+		default:
+			return fmt.Errorf("token validation failed: %w", err)
+		}
+	}
+	return fmt.Errorf("SSO service unavailable: %w", err)
 }
@@ -0,0 +1,129 @@
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// ErrNotAcquired is returned when the lock is held by someone else.
+	ErrNotAcquired = errors.New("lock not acquired")
+	// ErrNotHeld is returned when extending a lock that expired.
+	ErrNotHeld = errors.New("lock not held")
+)
+
+// releaseScript deletes the lock only if it is still owned by the caller.
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// extendScript renews the TTL only if the lock is still owned by the caller.
+var extendScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// Locker hands out short Redis locks shared by all gateway replicas. Locks
+// expire on their own, so a crashed holder cannot block others for long.
+type Locker struct {
+	client *redis.Client
+}
+
+func New(client *redis.Client) *Locker {
+	return &Locker{client: client}
+}
+
+type Lock struct {
+	client *redis.Client
+	key    string
+	token  string
+}
+
+// Acquire takes the lock for ttl without waiting. It returns ErrNotAcquired
+// when the lock is taken.
+func (l *Locker) Acquire(ctx context.Context, key string, ttl time.Duration) (*Lock, error) {
+	const op = "lock.Acquire"
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	token := hex.EncodeToString(b)
+
+	ok, err := l.client.SetNX(ctx, key, token, ttl).Result()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if !ok {
+		return nil, ErrNotAcquired
+	}
+	return &Lock{client: l.client, key: key, token: token}, nil
+}
+
+// Extend sets the TTL of the lock to ttl again. It returns ErrNotHeld when
+// the lock expired in the meantime.
+func (l *Lock) Extend(ctx context.Context, ttl time.Duration) error {
+	const op = "lock.Extend"
+
+	ok, err := extendScript.Run(ctx, l.client, []string{l.key}, l.token, ttl.Milliseconds()).Int()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if ok == 0 {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+// KeepAlive extends the lock to ttl every ttl/3 until stop is called, so
+// a holder running longer than ttl keeps it while a crashed one does not.
+// Failures are passed to onErr; renewal ends once the lock is lost.
+func (l *Lock) KeepAlive(ttl time.Duration, onErr func(error)) (stop func()) {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := l.Extend(context.Background(), ttl)
+				if err == nil {
+					continue
+				}
+				onErr(err)
+				if errors.Is(err, ErrNotHeld) {
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+// Release frees the lock if it has not expired and been taken over yet.
+func (l *Lock) Release(ctx context.Context) error {
+	const op = "lock.Release"
+
+	if err := releaseScript.Run(ctx, l.client, []string{l.key}, l.token).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
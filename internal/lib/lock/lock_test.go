@@ -0,0 +1,96 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newLocker(t *testing.T) (*Locker, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return New(client), mr
+}
+
+func TestLockExtend(t *testing.T) {
+	locks, mr := newLocker(t)
+	ctx := context.Background()
+	l, err := locks.Acquire(ctx, "k", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := locks.Acquire(ctx, "k", time.Second); !errors.Is(err, ErrNotAcquired) {
+		t.Fatalf("second Acquire: err = %v, want ErrNotAcquired", err)
+	}
+
+	mr.FastForward(900 * time.Millisecond)
+	if err := l.Extend(ctx, time.Second); err != nil {
+		t.Fatalf("Extend: %v", err)
+	}
+	mr.FastForward(900 * time.Millisecond)
+	if !mr.Exists("k") {
+		t.Fatal("lock expired after Extend")
+	}
+
+	mr.FastForward(time.Second)
+	if err := l.Extend(ctx, time.Second); !errors.Is(err, ErrNotHeld) {
+		t.Fatalf("Extend after expiry: err = %v, want ErrNotHeld", err)
+	}
+	other, err := locks.Acquire(ctx, "k", time.Second)
+	if err != nil {
+		t.Fatalf("Acquire after expiry: %v", err)
+	}
+	if err := l.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !mr.Exists("k") {
+		t.Fatal("expired holder released the lock of another")
+	}
+	if err := other.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockKeepAlive(t *testing.T) {
+	locks, mr := newLocker(t)
+	ctx := context.Background()
+	const ttl = 30 * time.Millisecond
+	l, err := locks.Acquire(ctx, "k", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var failures atomic.Int32
+	stop := l.KeepAlive(ttl, func(error) { failures.Add(1) })
+	// miniredis expires keys only on FastForward, so shorten the TTL by
+	// hand and expect the renewal to restore it.
+	mr.SetTTL("k", time.Millisecond)
+	time.Sleep(3 * ttl)
+	stop()
+	if got := mr.TTL("k"); got != ttl {
+		t.Fatalf("TTL = %v, want it renewed to %v", got, ttl)
+	}
+	if n := failures.Load(); n != 0 {
+		t.Fatalf("renewal failed %d times", n)
+	}
+
+	mr.Del("k")
+	failed := make(chan error, 1)
+	stop = l.KeepAlive(ttl, func(err error) { failed <- err })
+	defer stop()
+	select {
+	case err := <-failed:
+		if !errors.Is(err, ErrNotHeld) {
+			t.Fatalf("renewal of a lost lock: err = %v, want ErrNotHeld", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("renewal of a lost lock did not fail")
+	}
+}
@@ -0,0 +1,130 @@
+package revocation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Citadelas/api-gateway/internal/lib/jwt"
+	"github.com/redis/go-redis/v9"
+)
+
+// Store is a Redis denylist of access tokens. Single tokens are revoked by
+// jti (or a hash of the token when it has none) until they expire; all
+// tokens of a user are revoked by remembering a "not issued before" cutoff.
+type Store struct {
+	client *redis.Client
+	// userTTL is how long a user cutoff is kept. It must be at least the
+	// lifetime of the longest living access token.
+	userTTL time.Duration
+}
+
+// Session is what the denylist needs to know about an access token. It is
+// kept instead of the token by connections that outlive the request, such
+// as task event streams, to check the token again later.
+type Session struct {
+	UserID uint64 `json:"uid"`
+	// Key identifies the token in the denylist: its jti or a hash.
+	Key string `json:"key"`
+	// IssuedAt and ExpiresAt are Unix times, 0 when the token has none.
+	IssuedAt  int64 `json:"iat,omitempty"`
+	ExpiresAt int64 `json:"exp,omitempty"`
+}
+
+// SessionOf returns the session of a validated token.
+func SessionOf(token string, claims *jwt.CustomClaims) Session {
+	s := Session{UserID: claims.UserID, Key: tokenID(token, claims)}
+	if claims.IssuedAt != nil {
+		s.IssuedAt = claims.IssuedAt.Unix()
+	}
+	if claims.ExpiresAt != nil {
+		s.ExpiresAt = claims.ExpiresAt.Unix()
+	}
+	return s
+}
+
+func New(client *redis.Client, userTTL time.Duration) *Store {
+	return &Store{client: client, userTTL: userTTL}
+}
+
+// RevokeToken denylists the token until its exp.
+func (s *Store) RevokeToken(ctx context.Context, token string, claims *jwt.CustomClaims) error {
+	const op = "revocation.RevokeToken"
+
+	ttl := s.userTTL
+	if claims.ExpiresAt != nil {
+		ttl = time.Until(claims.ExpiresAt.Time)
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	if err := s.client.Set(ctx, tokenKey(token, claims), 1, ttl).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// RevokeUser revokes every token of the user issued at or before the given
+// time, which is what "log out everywhere" needs.
+func (s *Store) RevokeUser(ctx context.Context, userID uint64, before time.Time) error {
+	const op = "revocation.RevokeUser"
+
+	err := s.client.Set(ctx, userKey(userID), before.Unix(), s.userTTL).Err()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// IsRevoked reports whether the token was revoked on its own or by a user
+// wide cutoff. Tokens without iat are treated as revoked by a cutoff.
+func (s *Store) IsRevoked(ctx context.Context, token string, claims *jwt.CustomClaims) (bool, error) {
+	return s.IsSessionRevoked(ctx, SessionOf(token, claims))
+}
+
+// IsSessionRevoked is IsRevoked for a token known only by its session.
+func (s *Store) IsSessionRevoked(ctx context.Context, session Session) (bool, error) {
+	const op = "revocation.IsRevoked"
+
+	pipe := s.client.Pipeline()
+	tokenCmd := pipe.Exists(ctx, "revoked:"+session.Key)
+	userCmd := pipe.Get(ctx, userKey(session.UserID))
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tokenCmd.Val() > 0 {
+		return true, nil
+	}
+	cutoff, err := userCmd.Int64()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	if session.IssuedAt == 0 {
+		return true, nil
+	}
+	return session.IssuedAt <= cutoff, nil
+}
+
+func tokenKey(token string, claims *jwt.CustomClaims) string {
+	return "revoked:" + tokenID(token, claims)
+}
+
+func tokenID(token string, claims *jwt.CustomClaims) string {
+	if claims.ID != "" {
+		return "jti:" + claims.ID
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:])
+}
+
+func userKey(userID uint64) string {
+	return "revoked:user:" + strconv.FormatUint(userID, 10)
+}
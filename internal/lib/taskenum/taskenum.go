@@ -0,0 +1,77 @@
+// Package taskenum maps the task priority and status strings of the public
+// API to taskv1 enums and back. Parsing is case-insensitive, "-" and " "
+// are accepted in place of "_".
+package taskenum
+
+import (
+	"errors"
+	"strings"
+
+	taskv1 "github.com/Citadelas/protos/golang/task"
+)
+
+var ErrUnknown = errors.New("unknown enum value")
+
+var priorities = map[string]taskv1.TaskPriority{
+	"low":    taskv1.TaskPriority_LOW,
+	"medium": taskv1.TaskPriority_MEDIUM,
+	"high":   taskv1.TaskPriority_HIGH,
+}
+
+var statuses = map[string]taskv1.TaskStatus{
+	"todo":        taskv1.TaskStatus_TODO,
+	"in_progress": taskv1.TaskStatus_IN_PROGRESS,
+	"done":        taskv1.TaskStatus_DONE,
+}
+
+// Priorities and Statuses list the accepted values in enum order.
+var (
+	Priorities = []string{"low", "medium", "high"}
+	Statuses   = []string{"todo", "in_progress", "done"}
+)
+
+func normalize(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return strings.NewReplacer("-", "_", " ", "_").Replace(s)
+}
+
+// ParsePriority returns the priority named s.
+func ParsePriority(s string) (taskv1.TaskPriority, error) {
+	p, ok := priorities[normalize(s)]
+	if !ok {
+		return 0, ErrUnknown
+	}
+	return p, nil
+}
+
+// PriorityString returns the API name of p, or an empty string for values
+// unknown to this gateway.
+func PriorityString(p taskv1.TaskPriority) string {
+	for name, v := range priorities {
+		if v == p {
+			return name
+		}
+	}
+	return ""
+}
+
+// ParseStatus returns the status named s. The unspecified status is not
+// accepted.
+func ParseStatus(s string) (taskv1.TaskStatus, error) {
+	st, ok := statuses[normalize(s)]
+	if !ok {
+		return 0, ErrUnknown
+	}
+	return st, nil
+}
+
+// StatusString returns the API name of s, or an empty string for the
+// unspecified status and values unknown to this gateway.
+func StatusString(s taskv1.TaskStatus) string {
+	for name, v := range statuses {
+		if v == s {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,79 @@
+package taskevents
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/Citadelas/api-gateway/internal/lib/logger/sl"
+	taskv1 "github.com/Citadelas/protos/golang/task"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Client is a TaskServiceClient that publishes an event for every
+// successful mutation going through it. Publish errors are logged and
+// never fail a call.
+type Client struct {
+	taskv1.TaskServiceClient
+	publisher *Publisher
+	// encode returns the public representation of a task for events.
+	encode func(*taskv1.Task) any
+	log    *slog.Logger
+}
+
+func WrapClient(client taskv1.TaskServiceClient, publisher *Publisher, encode func(*taskv1.Task) any, log *slog.Logger) *Client {
+	return &Client{
+		TaskServiceClient: client,
+		publisher:         publisher,
+		encode:            encode,
+		log:               log.With("op", "taskevents.Client"),
+	}
+}
+
+func (c *Client) CreateTask(ctx context.Context, in *taskv1.CreateTaskRequest, opts ...grpc.CallOption) (*taskv1.CreateTaskResponse, error) {
+	resp, err := c.TaskServiceClient.CreateTask(ctx, in, opts...)
+	if err == nil {
+		c.publish(ctx, in.GetUserId(), TypeCreated, resp.GetTask().GetId(), resp.GetTask())
+	}
+	return resp, err
+}
+
+func (c *Client) UpdateTask(ctx context.Context, in *taskv1.UpdateTaskRequest, opts ...grpc.CallOption) (*taskv1.UpdateTaskResponse, error) {
+	resp, err := c.TaskServiceClient.UpdateTask(ctx, in, opts...)
+	if err == nil {
+		c.publish(ctx, in.GetUserId(), TypeUpdated, in.GetId(), resp.GetTask())
+	}
+	return resp, err
+}
+
+func (c *Client) UpdateStatus(ctx context.Context, in *taskv1.UpdateStatusRequest, opts ...grpc.CallOption) (*taskv1.UpdateStatusResponse, error) {
+	resp, err := c.TaskServiceClient.UpdateStatus(ctx, in, opts...)
+	if err == nil {
+		c.publish(ctx, in.GetUserId(), TypeStatusChanged, in.GetId(), resp.GetTask())
+	}
+	return resp, err
+}
+
+func (c *Client) DeleteTask(ctx context.Context, in *taskv1.DeleteTaskRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	resp, err := c.TaskServiceClient.DeleteTask(ctx, in, opts...)
+	if err == nil {
+		c.publish(ctx, in.GetUserId(), TypeDeleted, in.GetId(), nil)
+	}
+	return resp, err
+}
+
+func (c *Client) publish(ctx context.Context, userID uint64, typ string, id uint64, t *taskv1.Task) {
+	e := Event{Type: typ, TaskID: id}
+	if t != nil {
+		data, err := json.Marshal(c.encode(t))
+		if err != nil {
+			c.log.ErrorContext(ctx, "Failed to encode task event", sl.Err(err))
+			return
+		}
+		e.Task = data
+	}
+	if err := c.publisher.Publish(context.WithoutCancel(ctx), userID, e); err != nil {
+		c.log.ErrorContext(ctx, "Failed to publish task event", sl.Err(err))
+	}
+}
@@ -0,0 +1,138 @@
+package taskevents
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+
+	"github.com/Citadelas/api-gateway/internal/lib/logger/sl"
+	"github.com/redis/go-redis/v9"
+)
+
+// Hub fans the events received over pub/sub out to the subscriptions of
+// this replica.
+type Hub struct {
+	log    *slog.Logger
+	client *redis.Client
+	// buffer is how many undelivered events a subscription may hold.
+	buffer int
+
+	mu   sync.Mutex
+	subs map[uint64]map[*Subscription]struct{}
+	done bool
+}
+
+// Subscription receives the live events of one user. It is dropped when
+// its buffer is full, so a slow client cannot hold back others; Done is
+// closed then and the client is expected to reconnect and resume.
+type Subscription struct {
+	C    <-chan Event
+	Done <-chan struct{}
+
+	hub    *Hub
+	userID uint64
+	ch     chan Event
+	done   chan struct{}
+	once   sync.Once
+}
+
+func NewHub(log *slog.Logger, client *redis.Client, buffer int) *Hub {
+	return &Hub{
+		log:    log.With("op", "taskevents.Hub"),
+		client: client,
+		buffer: buffer,
+		subs:   make(map[uint64]map[*Subscription]struct{}),
+	}
+}
+
+// Start receives events until ctx is done, then ends every subscription.
+func (h *Hub) Start(ctx context.Context) {
+	ps := h.client.Subscribe(ctx, channel)
+	go func() {
+		defer ps.Close()
+		defer h.closeAll()
+		ch := ps.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				var m message
+				if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+					h.log.Error("Invalid task event message", sl.Err(err))
+					continue
+				}
+				h.dispatch(m.UserID, m.Event)
+			}
+		}
+	}()
+}
+
+// Subscribe registers a subscription to the events of the user.
+func (h *Hub) Subscribe(userID uint64) *Subscription {
+	s := &Subscription{
+		hub:    h,
+		userID: userID,
+		ch:     make(chan Event, h.buffer),
+		done:   make(chan struct{}),
+	}
+	s.C, s.Done = s.ch, s.done
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.done {
+		s.once.Do(func() { close(s.done) })
+		return s
+	}
+	if h.subs[userID] == nil {
+		h.subs[userID] = make(map[*Subscription]struct{})
+	}
+	h.subs[userID][s] = struct{}{}
+	return s
+}
+
+// Close unregisters the subscription.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
+
+func (h *Hub) dispatch(userID uint64, e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs[userID] {
+		select {
+		case s.ch <- e:
+		default:
+			h.log.Warn("Dropping slow task event subscriber", slog.Uint64("user_id", userID))
+			h.remove(s)
+		}
+	}
+}
+
+// remove must be called with h.mu held.
+func (h *Hub) remove(s *Subscription) {
+	if subs := h.subs[s.userID]; subs != nil {
+		delete(subs, s)
+		if len(subs) == 0 {
+			delete(h.subs, s.userID)
+		}
+	}
+	s.once.Do(func() { close(s.done) })
+}
+
+func (h *Hub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.done = true
+	for _, subs := range h.subs {
+		for s := range subs {
+			h.remove(s)
+		}
+	}
+}
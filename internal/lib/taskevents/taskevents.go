@@ -0,0 +1,158 @@
+// Package taskevents delivers task change events to the real-time streams
+// of every gateway replica. Events are appended to a capped Redis stream
+// per user, for resuming after a reconnect, and announced over pub/sub.
+package taskevents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	TypeCreated       = "task.created"
+	TypeUpdated       = "task.updated"
+	TypeStatusChanged = "task.status_changed"
+	TypeDeleted       = "task.deleted"
+	// TypeReset tells a resuming client that events it missed are no longer
+	// kept, so it has to fetch the tasks again.
+	TypeReset = "stream.reset"
+)
+
+// channel carries the events of all users, every replica dispatches them
+// to its own connections.
+const channel = "tasks:events"
+
+type Event struct {
+	// ID is the Redis stream entry ID, used as the SSE event id and for
+	// resuming with Last-Event-ID.
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	TaskID uint64 `json:"task_id,omitempty"`
+	// Task is the public task representation, absent for deletions.
+	Task json.RawMessage `json:"task,omitempty"`
+	Time time.Time       `json:"time"`
+}
+
+// message is the pub/sub payload.
+type message struct {
+	UserID uint64 `json:"user_id"`
+	Event  Event  `json:"event"`
+}
+
+// Publisher records events of users.
+type Publisher struct {
+	client *redis.Client
+	// maxLen caps the stream of a user, which bounds how far back a
+	// reconnecting client can resume.
+	maxLen int64
+}
+
+func NewPublisher(client *redis.Client, maxLen int64) *Publisher {
+	return &Publisher{client: client, maxLen: maxLen}
+}
+
+// Publish appends the event to the stream of the user and announces it.
+func (p *Publisher) Publish(ctx context.Context, userID uint64, e Event) error {
+	const op = "taskevents.Publish"
+
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	id, err := p.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: streamKey(userID),
+		MaxLen: p.maxLen,
+		Approx: true,
+		Values: map[string]any{"data": data},
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	e.ID = id
+
+	payload, err := json.Marshal(message{UserID: userID, Event: e})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := p.client.Publish(ctx, channel, payload).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// Since returns the stored events of the user after the event lastID. When
+// lastID is older than every kept event, the events in between were trimmed
+// and Since returns a single TypeReset event with the ID of the newest kept
+// event instead.
+func (h *Hub) Since(ctx context.Context, userID uint64, lastID string) ([]Event, error) {
+	const op = "taskevents.Since"
+
+	key := streamKey(userID)
+	entries, err := h.client.XRange(ctx, key, lastID, "+").Result()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if len(entries) == 0 || entries[0].ID != lastID {
+		oldest, err := h.client.XRangeN(ctx, key, "-", "+", 1).Result()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if len(oldest) == 0 || After(oldest[0].ID, lastID) {
+			reset := Event{Type: TypeReset, Time: time.Now().UTC()}
+			if len(entries) > 0 {
+				reset.ID = entries[len(entries)-1].ID
+			}
+			return []Event{reset}, nil
+		}
+	}
+	events := make([]Event, 0, len(entries))
+	for _, entry := range entries {
+		if entry.ID == lastID {
+			continue
+		}
+		data, _ := entry.Values["data"].(string)
+		var e Event
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			continue
+		}
+		e.ID = entry.ID
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// ValidID reports whether id is a stream entry ID ("<ms>-<seq>").
+func ValidID(id string) bool {
+	_, _, ok := parseID(id)
+	return ok
+}
+
+// After reports whether the stream entry ID a is newer than b.
+func After(a, b string) bool {
+	ams, aseq, _ := parseID(a)
+	bms, bseq, _ := parseID(b)
+	return ams > bms || (ams == bms && aseq > bseq)
+}
+
+func parseID(id string) (ms, seq uint64, ok bool) {
+	msPart, seqPart, found := strings.Cut(id, "-")
+	if !found {
+		return 0, 0, false
+	}
+	ms, err1 := strconv.ParseUint(msPart, 10, 64)
+	seq, err2 := strconv.ParseUint(seqPart, 10, 64)
+	return ms, seq, err1 == nil && err2 == nil
+}
+
+func streamKey(userID uint64) string {
+	return "tasks:events:user:" + strconv.FormatUint(userID, 10)
+}
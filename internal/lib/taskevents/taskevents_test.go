@@ -0,0 +1,94 @@
+package taskevents
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestClient(t *testing.T) *redis.Client {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestHubSince(t *testing.T) {
+	const user = 1
+	client := newTestClient(t)
+	hub := NewHub(slog.New(slog.NewTextHandler(io.Discard, nil)), client, 1)
+	ctx := context.Background()
+
+	// The stream keeps the last 3 of the events 1-0 to 5-0.
+	ids := []string{"1-0", "2-0", "3-0", "4-0", "5-0"}
+	for _, id := range ids {
+		err := client.XAdd(ctx, &redis.XAddArgs{
+			Stream: streamKey(user),
+			MaxLen: 3,
+			ID:     id,
+			Values: map[string]any{"data": `{"type":"task.created","task_id":1}`},
+		}).Err()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		userID    uint64
+		lastID    string
+		wantIDs   []string
+		wantReset bool
+	}{
+		{name: "newest event", userID: user, lastID: ids[4], wantIDs: []string{}},
+		{name: "oldest kept event", userID: user, lastID: ids[2], wantIDs: ids[3:]},
+		{name: "ID between kept events", userID: user, lastID: "4-5", wantIDs: ids[4:]},
+		{name: "trimmed event", userID: user, lastID: ids[1], wantIDs: ids[4:], wantReset: true},
+		{name: "before every event", userID: user, lastID: "0-1", wantIDs: ids[4:], wantReset: true},
+		{name: "empty stream", userID: user + 1, lastID: ids[4], wantIDs: []string{""}, wantReset: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := hub.Since(ctx, tt.userID, tt.lastID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(events))
+			for _, e := range events {
+				got = append(got, e.ID)
+			}
+			if !slices.Equal(got, tt.wantIDs) {
+				t.Fatalf("ids = %v, want %v", got, tt.wantIDs)
+			}
+			reset := len(events) == 1 && events[0].Type == TypeReset
+			if reset != tt.wantReset {
+				t.Fatalf("reset = %v, want %v", reset, tt.wantReset)
+			}
+		})
+	}
+}
+
+func TestAfter(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"2-0", "1-0", true},
+		{"1-1", "1-0", true},
+		{"10-0", "9-5", true},
+		{"1-0", "1-0", false},
+		{"1-0", "1-1", false},
+		{"9-5", "10-0", false},
+	}
+	for _, tt := range tests {
+		if got := After(tt.a, tt.b); got != tt.want {
+			t.Errorf("After(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package taskindex
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/Citadelas/api-gateway/internal/lib/logger/sl"
+	taskv1 "github.com/Citadelas/protos/golang/task"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Client is a TaskServiceClient that keeps the index in sync with the
+// mutations going through it. Reads only drop tasks the service no longer
+// knows. Index errors are logged and never fail a call.
+type Client struct {
+	taskv1.TaskServiceClient
+	index *Index
+	log   *slog.Logger
+}
+
+func WrapClient(client taskv1.TaskServiceClient, index *Index, log *slog.Logger) *Client {
+	return &Client{
+		TaskServiceClient: client,
+		index:             index,
+		log:               log.With("op", "taskindex.Client"),
+	}
+}
+
+func (c *Client) CreateTask(ctx context.Context, in *taskv1.CreateTaskRequest, opts ...grpc.CallOption) (*taskv1.CreateTaskResponse, error) {
+	resp, err := c.TaskServiceClient.CreateTask(ctx, in, opts...)
+	if err == nil {
+		c.add(ctx, in.GetUserId(), resp.GetTask().GetId())
+	}
+	return resp, err
+}
+
+func (c *Client) GetTask(ctx context.Context, in *taskv1.GetTaskRequest, opts ...grpc.CallOption) (*taskv1.GetTaskResponse, error) {
+	resp, err := c.TaskServiceClient.GetTask(ctx, in, opts...)
+	if status.Code(err) == codes.NotFound {
+		c.remove(ctx, in.GetUserId(), in.GetId())
+	}
+	return resp, err
+}
+
+func (c *Client) UpdateTask(ctx context.Context, in *taskv1.UpdateTaskRequest, opts ...grpc.CallOption) (*taskv1.UpdateTaskResponse, error) {
+	resp, err := c.TaskServiceClient.UpdateTask(ctx, in, opts...)
+	c.observe(ctx, in.GetUserId(), in.GetId(), err)
+	return resp, err
+}
+
+func (c *Client) UpdateStatus(ctx context.Context, in *taskv1.UpdateStatusRequest, opts ...grpc.CallOption) (*taskv1.UpdateStatusResponse, error) {
+	resp, err := c.TaskServiceClient.UpdateStatus(ctx, in, opts...)
+	c.observe(ctx, in.GetUserId(), in.GetId(), err)
+	return resp, err
+}
+
+func (c *Client) DeleteTask(ctx context.Context, in *taskv1.DeleteTaskRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	resp, err := c.TaskServiceClient.DeleteTask(ctx, in, opts...)
+	if err == nil || status.Code(err) == codes.NotFound {
+		c.remove(ctx, in.GetUserId(), in.GetId())
+	}
+	return resp, err
+}
+
+// observe adds a task that exists and drops one the service does not know.
+func (c *Client) observe(ctx context.Context, userID, id uint64, err error) {
+	switch {
+	case err == nil:
+		c.add(ctx, userID, id)
+	case status.Code(err) == codes.NotFound:
+		c.remove(ctx, userID, id)
+	}
+}
+
+func (c *Client) add(ctx context.Context, userID, id uint64) {
+	if err := c.index.Add(context.WithoutCancel(ctx), userID, id); err != nil {
+		c.log.ErrorContext(ctx, "Failed to index task", sl.Err(err))
+	}
+}
+
+func (c *Client) remove(ctx context.Context, userID, id uint64) {
+	if err := c.index.Remove(context.WithoutCancel(ctx), userID, id); err != nil {
+		c.log.ErrorContext(ctx, "Failed to unindex task", sl.Err(err))
+	}
+}
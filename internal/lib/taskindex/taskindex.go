@@ -0,0 +1,102 @@
+// Package taskindex keeps a Redis index of the task IDs of every user. The
+// task service has no list RPC, so the gateway remembers the tasks it has
+// seen and lists them through GetTask.
+package taskindex
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// maxPerUser bounds the index of a single user, the oldest IDs are dropped
+// first.
+const maxPerUser = 10000
+
+// Index stores task IDs in a sorted set per user, scored by ID, so the
+// newest tasks come first.
+type Index struct {
+	client *redis.Client
+}
+
+func New(client *redis.Client) *Index {
+	return &Index{client: client}
+}
+
+// Add records tasks of the user.
+func (i *Index) Add(ctx context.Context, userID uint64, ids ...uint64) error {
+	const op = "taskindex.Add"
+
+	if len(ids) == 0 {
+		return nil
+	}
+	members := make([]redis.Z, len(ids))
+	for n, id := range ids {
+		members[n] = redis.Z{Score: float64(id), Member: strconv.FormatUint(id, 10)}
+	}
+	key := userKey(userID)
+	pipe := i.client.TxPipeline()
+	pipe.ZAdd(ctx, key, members...)
+	pipe.ZRemRangeByRank(ctx, key, 0, -maxPerUser-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// Remove forgets tasks of the user.
+func (i *Index) Remove(ctx context.Context, userID uint64, ids ...uint64) error {
+	const op = "taskindex.Remove"
+
+	if len(ids) == 0 {
+		return nil
+	}
+	members := make([]any, len(ids))
+	for n, id := range ids {
+		members[n] = strconv.FormatUint(id, 10)
+	}
+	if err := i.client.ZRem(ctx, userKey(userID), members...).Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// IDs returns up to limit task IDs of the user ordered by ID, newest first
+// when desc. A non-zero after skips IDs up to and including it in that
+// order, for paging.
+func (i *Index) IDs(ctx context.Context, userID uint64, after uint64, desc bool, limit int) ([]uint64, error) {
+	const op = "taskindex.IDs"
+
+	opt := &redis.ZRangeBy{Min: "-inf", Max: "+inf", Count: int64(limit)}
+	var members []string
+	var err error
+	if desc {
+		if after != 0 {
+			opt.Max = "(" + strconv.FormatUint(after, 10)
+		}
+		members, err = i.client.ZRevRangeByScore(ctx, userKey(userID), opt).Result()
+	} else {
+		if after != 0 {
+			opt.Min = "(" + strconv.FormatUint(after, 10)
+		}
+		members, err = i.client.ZRangeByScore(ctx, userKey(userID), opt).Result()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	ids := make([]uint64, 0, len(members))
+	for _, m := range members {
+		id, err := strconv.ParseUint(m, 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func userKey(userID uint64) string {
+	return "tasks:index:user:" + strconv.FormatUint(userID, 10)
+}
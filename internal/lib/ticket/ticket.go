@@ -0,0 +1,88 @@
+// Package ticket issues short-lived single-use tickets that authenticate a
+// user where no Authorization header can be sent, e.g. browser EventSource
+// and WebSocket connections.
+package ticket
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Citadelas/api-gateway/internal/lib/revocation"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrInvalid is returned for unknown, expired and already used tickets.
+var ErrInvalid = errors.New("invalid ticket")
+
+// Store keeps tickets in Redis under a hash of their value, so the store
+// does not hold usable tickets.
+type Store struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func New(client *redis.Client, ttl time.Duration) *Store {
+	return &Store{client: client, ttl: ttl}
+}
+
+// TTL is how long an issued ticket stays valid.
+func (s *Store) TTL() time.Duration {
+	return s.ttl
+}
+
+// Issue returns a new ticket for the session of the token it was requested
+// with. The ticket does not outlive the token.
+func (s *Store) Issue(ctx context.Context, session revocation.Session) (string, error) {
+	const op = "ticket.Issue"
+
+	ttl := s.ttl
+	if session.ExpiresAt != 0 {
+		ttl = min(ttl, time.Until(time.Unix(session.ExpiresAt, 0)))
+	}
+	if ttl <= 0 {
+		return "", ErrInvalid
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	t := base64.RawURLEncoding.EncodeToString(b)
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	if err := s.client.Set(ctx, key(t), data, ttl).Err(); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	return t, nil
+}
+
+// Redeem consumes the ticket and returns the session it was issued for.
+func (s *Store) Redeem(ctx context.Context, t string) (revocation.Session, error) {
+	const op = "ticket.Redeem"
+
+	v, err := s.client.GetDel(ctx, key(t)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return revocation.Session{}, ErrInvalid
+	}
+	if err != nil {
+		return revocation.Session{}, fmt.Errorf("%s: %w", op, err)
+	}
+	var session revocation.Session
+	if err := json.Unmarshal(v, &session); err != nil {
+		return revocation.Session{}, ErrInvalid
+	}
+	return session, nil
+}
+
+func key(t string) string {
+	sum := sha256.Sum256([]byte(t))
+	return "ticket:" + hex.EncodeToString(sum[:])
+}
@@ -1,26 +1,74 @@
 package middleware
 
 import (
+	"errors"
+
+	"github.com/Citadelas/api-gateway/internal/helpers/problem"
 	"github.com/Citadelas/api-gateway/internal/lib/jwt"
-	ssov1 "github.com/Citadelas/protos/golang/sso"
+	"github.com/Citadelas/api-gateway/internal/lib/revocation"
+	"github.com/Citadelas/api-gateway/internal/lib/ticket"
 	"github.com/gin-gonic/gin"
 )
 
-func AuthMiddleware(ssoClient ssov1.AuthClient) gin.HandlerFunc {
+func AuthMiddleware(validator *jwt.Validator, denylist *revocation.Store) gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
 		token := jwt.ExtractToken(c.GetHeader("Authorization"))
 
-		userID, err := jwt.ValidateToken(c.Request.Context(), ssoClient, token)
+		claims, err := validator.Validate(c.Request.Context(), token)
 		if err != nil {
-			c.JSON(401, gin.H{
-				"error":   "unauthorized",
-				"details": err.Error(),
-			})
-			c.Abort()
+			problem.Abort(c, 401, err.Error())
 			return
 		}
 
-		c.Set("userID", userID)
+		revoked, err := denylist.IsRevoked(c.Request.Context(), token, claims)
+		if err != nil {
+			problem.Abort(c, 503, "failed to check token revocation")
+			return
+		}
+		if revoked {
+			problem.Abort(c, 401, "token revoked")
+			return
+		}
+
+		c.Set("userID", claims.UserID)
+		c.Set("claims", claims)
+		c.Set("session", revocation.SessionOf(token, claims))
 		c.Next()
 	})
 }
+
+// TicketAuthMiddleware authenticates like AuthMiddleware, or with a
+// single-use ticket in the "ticket" query parameter for clients that cannot
+// send headers. Ticket requests get the user ID and the session of the
+// token the ticket was issued for, but no claims.
+func TicketAuthMiddleware(validator *jwt.Validator, denylist *revocation.Store, tickets *ticket.Store) gin.HandlerFunc {
+	auth := AuthMiddleware(validator, denylist)
+	return func(c *gin.Context) {
+		t := c.Query("ticket")
+		if t == "" {
+			auth(c)
+			return
+		}
+		session, err := tickets.Redeem(c.Request.Context(), t)
+		if errors.Is(err, ticket.ErrInvalid) {
+			problem.Abort(c, 401, err.Error())
+			return
+		}
+		if err != nil {
+			problem.Abort(c, 503, "failed to check ticket")
+			return
+		}
+		revoked, err := denylist.IsSessionRevoked(c.Request.Context(), session)
+		if err != nil {
+			problem.Abort(c, 503, "failed to check token revocation")
+			return
+		}
+		if revoked {
+			problem.Abort(c, 401, "token revoked")
+			return
+		}
+		c.Set("userID", session.UserID)
+		c.Set("session", session)
+		c.Next()
+	}
+}
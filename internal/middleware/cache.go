@@ -1,59 +1,471 @@
 package middleware
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"log/slog"
+	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Citadelas/api-gateway/internal/config"
+	"github.com/Citadelas/api-gateway/internal/helpers/problem"
+	"github.com/Citadelas/api-gateway/internal/lib/lock"
+	"github.com/Citadelas/api-gateway/internal/lib/logger/sl"
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
 )
 
-type bodyWriter struct {
-	gin.ResponseWriter
-	body *bytes.Buffer
+// CacheTagsKey is the context key handlers can set to a []string of extra
+// tags to purge when their mutation succeeds.
+const CacheTagsKey = "cacheTags"
+
+// CacheTagFunc returns the resource tags of a request. GET responses are
+// stored under these tags and successful mutations purge them.
+type CacheTagFunc func(c *gin.Context) []string
+
+// purgeTagsScript deletes every cache entry of the given tag sets and the
+// sets themselves. KEYS are tag set keys. Returns the deleted entry keys.
+var purgeTagsScript = redis.NewScript(`
+local purged = {}
+for _, tag in ipairs(KEYS) do
+	local members = redis.call('SMEMBERS', tag)
+	for _, key in ipairs(members) do
+		redis.call('DEL', key)
+		table.insert(purged, key)
+	end
+	redis.call('DEL', tag)
+end
+return purged
+`)
+
+// uncachedHeaders are response headers that describe a single response and
+// must not be replayed from the cache.
+var uncachedHeaders = []string{"Set-Cookie", "Date", "Content-Length", "Connection", "X-Cache-Status"}
+
+const (
+	// cacheLockTTL bounds how long one replica may hold a key refresh lock.
+	cacheLockTTL = 5 * time.Second
+	// cacheLockWait is how long a replica waits for another one to fill
+	// the entry before going to the backend itself.
+	cacheLockWait = 2 * time.Second
+)
+
+type cacheEntry struct {
+	Status       int         `json:"status"`
+	Header       http.Header `json:"header"`
+	Body         []byte      `json:"body"`
+	ETag         string      `json:"etag"`
+	LastModified string      `json:"last_modified"`
+	ExpiresAt    time.Time   `json:"expires_at"`
+}
+
+func (e *cacheEntry) fresh() bool {
+	return time.Now().Before(e.ExpiresAt)
+}
+
+// staleWithin reports whether the expired entry may still be served
+// within the given stale window.
+func (e *cacheEntry) staleWithin(window time.Duration) bool {
+	return window > 0 && time.Now().Before(e.ExpiresAt.Add(window))
 }
 
-func (w bodyWriter) Write(b []byte) (int, error) {
-	w.body.Write(b)
-	return w.ResponseWriter.Write(b)
+type responseCache struct {
+	log    *slog.Logger
+	client *redis.Client
+	locks  *lock.Locker
+	group  singleflight.Group
+	tagsOf CacheTagFunc
+	// local is the optional in-process tier, nil when disabled.
+	local *localCache
 }
 
-func CacheMiddleware(log *slog.Logger, client *redis.Client) gin.HandlerFunc {
+// CacheMiddleware caches GET responses of the routes that have a policy in
+// policies, matched by route pattern (gin FullPath). Entries keep the
+// status code, headers and body and carry an ETag and Last-Modified, so
+// conditional requests are answered with 304. Cache-Control no-store and
+// no-cache are honored both in requests and in handler responses.
+//
+// Concurrent misses of a key are coalesced within the process and guarded
+// by a short Redis lock across replicas. Policies may allow serving an
+// expired entry while one request refreshes it (stale-while-revalidate) or
+// while the backend fails (stale-if-error).
+//
+// With cfg.Local enabled an in-process LRU tier answers before Redis. Purges
+// are fanned out to the local tiers of all replicas through Redis pub/sub
+// until ctx is done.
+func CacheMiddleware(ctx context.Context, log *slog.Logger, client *redis.Client, cfg config.Cache, tagsOf CacheTagFunc) gin.HandlerFunc {
+	rc := &responseCache{
+		log:    log.With("op", "middleware.Cache"),
+		client: client,
+		locks:  lock.New(client),
+		tagsOf: tagsOf,
+	}
+	if cfg.Local.Enabled && cfg.Local.Size > 0 {
+		rc.local = newLocalCache(cfg.Local.Size, cfg.Local.TTL)
+		rc.local.subscribeInvalidations(ctx, rc.log, client)
+	}
+	byRoute := make(map[string]config.CachePolicy, len(cfg.Policies))
+	for _, p := range cfg.Policies {
+		byRoute[p.Route] = p
+	}
+
 	return func(ctx *gin.Context) {
 		if ctx.Request.Method != "GET" {
 			ctx.Next()
+			if status := ctx.Writer.Status(); status >= 200 && status < 300 {
+				rc.purge(ctx.Request.Context(), append(tagsOf(ctx), ctx.GetStringSlice(CacheTagsKey)...))
+			}
 			return
 		}
-		userID, exists := ctx.Get("userID")
-		if !exists {
-			ctx.JSON(401, "Unauthorized")
+
+		policy, ok := byRoute[ctx.FullPath()]
+		if !ok || policy.TTL <= 0 || hasDirective(ctx.GetHeader("Cache-Control"), "no-store") {
+			ctx.Next()
+			return
+		}
+		rc.serve(ctx, policy)
+	}
+}
+
+func (rc *responseCache) serve(ctx *gin.Context, policy config.CachePolicy) {
+	cacheKey, ok := cacheKeyFor(ctx, policy)
+	if !ok {
+		problem.Abort(ctx, 401, "missing user")
+		return
+	}
+
+	var stale *cacheEntry
+	if !hasDirective(ctx.GetHeader("Cache-Control"), "no-cache") {
+		if rc.local != nil {
+			if entry, ok := rc.local.get(cacheKey); ok && entry.fresh() {
+				observeCache(tierLocal, "hit")
+				rc.serveCached(ctx, entry, "HIT")
+				return
+			}
+			observeCache(tierLocal, "miss")
+		}
+
+		entry, err := loadEntry(ctx.Request.Context(), rc.client, cacheKey)
+		switch {
+		case err == nil && entry.fresh():
+			observeCache(tierRedis, "hit")
+			rc.storeLocal(cacheKey, entry)
+			rc.serveCached(ctx, entry, "HIT")
 			return
+		case err == nil:
+			observeCache(tierRedis, "stale")
+			stale = entry
+		case errors.Is(err, redis.Nil):
+			observeCache(tierRedis, "miss")
+		default:
+			observeCache(tierRedis, "error")
+			rc.log.ErrorContext(ctx, "Failed to read cache", sl.Err(err))
 		}
-		cacheKey := ctx.Request.URL.String() + ":" + strconv.Itoa(int(userID.(uint64)))
-		cached, err := client.Get(context.Background(), cacheKey).Result()
-		if err == nil {
-			ctx.Header("X-Cache-Status", "HIT")
-			ctx.Header("Content-Type", "application/json")
-			ctx.String(200, cached)
-			ctx.Abort()
+	}
+
+	if stale != nil && stale.staleWithin(policy.StaleWhileRevalidate) {
+		// Only the lock owner refreshes, everyone else gets the stale entry.
+		l, err := rc.locks.Acquire(ctx.Request.Context(), lockKey(cacheKey), cacheLockTTL)
+		if err != nil {
+			rc.serveCached(ctx, stale, "STALE")
+			return
+		}
+		defer rc.release(l)
+		rc.fetch(ctx, cacheKey, policy, stale)
+		return
+	}
+
+	leader := false
+	v, _, shared := rc.group.Do(cacheKey, func() (any, error) {
+		leader = true
+		return rc.fetchLocked(ctx, cacheKey, policy, stale), nil
+	})
+	if leader {
+		return
+	}
+	// Only cacheable responses are shared, anything else (errors, private
+	// responses) is fetched by every waiting request itself.
+	if entry, _ := v.(*cacheEntry); entry != nil && shared {
+		status := "HIT"
+		if !entry.fresh() {
+			status = "STALE"
+		}
+		rc.serveCached(ctx, entry, status)
+		return
+	}
+	ctx.Next()
+}
+
+// fetchLocked fetches the response under the Redis lock of the key. When
+// another replica holds the lock it waits for that replica's entry first.
+func (rc *responseCache) fetchLocked(ctx *gin.Context, cacheKey string, policy config.CachePolicy, stale *cacheEntry) *cacheEntry {
+	l, err := rc.locks.Acquire(ctx.Request.Context(), lockKey(cacheKey), cacheLockTTL)
+	switch {
+	case err == nil:
+		defer rc.release(l)
+	case errors.Is(err, lock.ErrNotAcquired):
+		if entry := rc.waitForEntry(ctx.Request.Context(), cacheKey); entry != nil {
+			rc.serveCached(ctx, entry, "HIT")
+			return entry
+		}
+	default:
+		rc.log.ErrorContext(ctx, "Failed to acquire cache lock", sl.Err(err))
+	}
+	return rc.fetch(ctx, cacheKey, policy, stale)
+}
+
+// fetch runs the rest of the chain, writes its response and stores it when
+// cacheable. It returns the entry to share with coalesced requests, nil when
+// the response must not be shared.
+func (rc *responseCache) fetch(ctx *gin.Context, cacheKey string, policy config.CachePolicy, stale *cacheEntry) *cacheEntry {
+	ctx.Header("X-Cache-Status", "MISS")
+	w := newBufferedWriter(ctx.Writer)
+	ctx.Writer = w
+	completed := false
+	// A panicking handler leaves the 500 to Recovery, like in
+	// IdempotencyMiddleware.
+	defer func() {
+		if completed {
 			return
 		}
-		ctx.Header("X-Cache-Status", "MISS")
-		blw := &bodyWriter{body: bytes.NewBufferString(""), ResponseWriter: ctx.Writer}
-		ctx.Writer = blw
-
-		ctx.Next()
-		if blw.Status() == 200 && blw.body.Len() > 0 {
-			log.Info("Saving to cache, key:", cacheKey, "body length:", blw.body.Len())
-			err := client.SetEx(context.Background(), cacheKey, blw.body.String(), time.Minute).Err()
-			if err != nil {
-				log.Error("Failed to save to Redis:", err)
-			} else {
-				log.Info("Successfully saved to Redis")
+		ctx.Writer = w.ResponseWriter
+		if w.Written() {
+			w.flush()
+		}
+	}()
+	ctx.Next()
+	completed = true
+	ctx.Writer = w.ResponseWriter
+
+	if w.Status() >= 500 && stale != nil && stale.staleWithin(policy.StaleIfError) {
+		rc.log.WarnContext(ctx, "Serving stale entry on backend error", slog.String("key", cacheKey), slog.Int("status", w.Status()))
+		rc.serveCached(ctx, stale, "STALE")
+		return stale
+	}
+
+	for k, v := range w.Header() {
+		ctx.Writer.Header()[k] = v
+	}
+	if !cacheable(w) {
+		w.flush()
+		return nil
+	}
+	entry := newEntry(w, policy.TTL)
+	serveEntry(ctx, entry)
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		rc.log.ErrorContext(ctx, "Failed to encode cache entry", sl.Err(err))
+		return entry
+	}
+	ttl := policy.TTL + max(policy.StaleWhileRevalidate, policy.StaleIfError)
+	rc.log.DebugContext(ctx, "Saving to cache", slog.String("key", cacheKey), slog.Int("body_length", len(entry.Body)))
+	if err := saveTagged(ctx.Request.Context(), rc.client, cacheKey, data, rc.tagsOf(ctx), ttl); err != nil {
+		rc.log.ErrorContext(ctx, "Failed to save to Redis", sl.Err(err))
+	}
+	rc.storeLocal(cacheKey, entry)
+	return entry
+}
+
+func (rc *responseCache) storeLocal(cacheKey string, entry *cacheEntry) {
+	if rc.local != nil {
+		rc.local.set(cacheKey, entry)
+	}
+}
+
+// purge deletes the entries of the tags from Redis and from the local tier
+// of every replica.
+func (rc *responseCache) purge(ctx context.Context, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	keys := make([]string, len(tags))
+	for i, tag := range tags {
+		keys[i] = tagKey(tag)
+	}
+	purged, err := purgeTagsScript.Run(ctx, rc.client, keys).StringSlice()
+	if err != nil {
+		rc.log.ErrorContext(ctx, "Failed to purge cache tags", sl.Err(err), slog.Any("tags", tags))
+		return
+	}
+	if rc.local == nil || len(purged) == 0 {
+		return
+	}
+	rc.local.delete(purged...)
+	msg, _ := json.Marshal(purged)
+	if err := rc.client.Publish(ctx, cacheInvalidateChannel, msg).Err(); err != nil {
+		rc.log.ErrorContext(ctx, "Failed to publish cache invalidation", sl.Err(err))
+	}
+}
+
+func (rc *responseCache) serveCached(ctx *gin.Context, entry *cacheEntry, status string) {
+	ctx.Header("X-Cache-Status", status)
+	serveEntry(ctx, entry)
+	ctx.Abort()
+}
+
+// waitForEntry polls for a fresh entry until cacheLockWait passes.
+func (rc *responseCache) waitForEntry(ctx context.Context, cacheKey string) *cacheEntry {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(cacheLockWait)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timeout:
+			return nil
+		case <-ticker.C:
+			entry, err := loadEntry(ctx, rc.client, cacheKey)
+			if err == nil && entry.fresh() {
+				return entry
 			}
 		}
 	}
 }
+
+func (rc *responseCache) release(l *lock.Lock) {
+	if err := l.Release(context.Background()); err != nil {
+		rc.log.Error("Failed to release cache lock", sl.Err(err))
+	}
+}
+
+func lockKey(cacheKey string) string {
+	return "lock:" + cacheKey
+}
+
+// cacheKeyFor builds the cache key from the request URI, the user when the
+// policy varies by user and the values of the policy vary headers.
+func cacheKeyFor(c *gin.Context, policy config.CachePolicy) (string, bool) {
+	var b strings.Builder
+	b.WriteString("cache:")
+	b.WriteString(c.Request.URL.RequestURI())
+	if policy.VaryByUser {
+		userID, exists := c.Get("userID")
+		if !exists {
+			return "", false
+		}
+		b.WriteString(":user:")
+		b.WriteString(strconv.FormatUint(userID.(uint64), 10))
+	}
+	if len(policy.VaryHeaders) > 0 {
+		h := sha256.New()
+		for _, name := range policy.VaryHeaders {
+			h.Write([]byte(http.CanonicalHeaderKey(name) + "=" + c.GetHeader(name) + "\n"))
+		}
+		b.WriteString(":vary:")
+		b.WriteString(hex.EncodeToString(h.Sum(nil)[:8]))
+	}
+	return b.String(), true
+}
+
+func cacheable(w *bufferedWriter) bool {
+	if w.Status() != http.StatusOK || w.body.Len() == 0 {
+		return false
+	}
+	cc := w.Header().Get("Cache-Control")
+	return !hasDirective(cc, "no-store") && !hasDirective(cc, "no-cache") && !hasDirective(cc, "private")
+}
+
+func newEntry(w *bufferedWriter, ttl time.Duration) *cacheEntry {
+	header := w.Header().Clone()
+	for _, name := range uncachedHeaders {
+		header.Del(name)
+	}
+	entry := &cacheEntry{
+		Status:       w.Status(),
+		Header:       header,
+		Body:         w.body.Bytes(),
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		ExpiresAt:    time.Now().Add(ttl),
+	}
+	if entry.ETag == "" {
+		sum := sha256.Sum256(entry.Body)
+		entry.ETag = `"` + hex.EncodeToString(sum[:16]) + `"`
+	}
+	if entry.LastModified == "" {
+		entry.LastModified = time.Now().UTC().Format(http.TimeFormat)
+	}
+	return entry
+}
+
+// serveEntry writes the entry, or 304 when the request validators match.
+func serveEntry(c *gin.Context, entry *cacheEntry) {
+	for k, v := range entry.Header {
+		c.Writer.Header()[k] = v
+	}
+	c.Header("ETag", entry.ETag)
+	c.Header("Last-Modified", entry.LastModified)
+
+	if notModified(c.Request, entry) {
+		c.Status(http.StatusNotModified)
+		c.Writer.WriteHeaderNow()
+		return
+	}
+	c.Status(entry.Status)
+	_, _ = c.Writer.Write(entry.Body)
+}
+
+func notModified(r *http.Request, entry *cacheEntry) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(entry.ETag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		since, err := http.ParseTime(ims)
+		modified, err2 := http.ParseTime(entry.LastModified)
+		return err == nil && err2 == nil && !modified.After(since)
+	}
+	return false
+}
+
+func hasDirective(cacheControl, directive string) bool {
+	for _, d := range strings.Split(cacheControl, ",") {
+		d = strings.TrimSpace(d)
+		if name, _, _ := strings.Cut(d, "="); strings.EqualFold(name, directive) {
+			return true
+		}
+	}
+	return false
+}
+
+func loadEntry(ctx context.Context, client *redis.Client, key string) (*cacheEntry, error) {
+	data, err := client.Get(ctx, key).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// saveTagged stores the entry and adds its key to every tag set. Tag sets
+// live as long as their newest entry.
+func saveTagged(ctx context.Context, client *redis.Client, key string, value []byte, tags []string, ttl time.Duration) error {
+	pipe := client.TxPipeline()
+	pipe.SetEx(ctx, key, value, ttl)
+	for _, tag := range tags {
+		pipe.SAdd(ctx, tagKey(tag), key)
+		pipe.Expire(ctx, tagKey(tag), ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func tagKey(tag string) string {
+	return "cache:tag:" + tag
+}
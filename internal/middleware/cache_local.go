@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	prometheus2 "github.com/Citadelas/api-gateway/internal/app/prometheus"
+	"github.com/Citadelas/api-gateway/internal/lib/logger/sl"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	tierLocal = "local"
+	tierRedis = "redis"
+)
+
+// cacheInvalidateChannel carries JSON arrays of purged cache keys, so every
+// replica can drop them from its local tier.
+const cacheInvalidateChannel = "cache:invalidate"
+
+// localCache is a size bounded in-process LRU tier in front of Redis.
+type localCache struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type localItem struct {
+	key     string
+	entry   *cacheEntry
+	expires time.Time
+}
+
+func newLocalCache(size int, ttl time.Duration) *localCache {
+	return &localCache{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[string]*list.Element, size),
+	}
+}
+
+func (l *localCache) get(key string) (*cacheEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	item := el.Value.(*localItem)
+	if time.Now().After(item.expires) {
+		l.removeElement(el)
+		return nil, false
+	}
+	l.ll.MoveToFront(el)
+	return item.entry, true
+}
+
+func (l *localCache) set(key string, entry *cacheEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	expires := time.Now().Add(l.ttl)
+	if el, ok := l.items[key]; ok {
+		item := el.Value.(*localItem)
+		item.entry, item.expires = entry, expires
+		l.ll.MoveToFront(el)
+		return
+	}
+	l.items[key] = l.ll.PushFront(&localItem{key: key, entry: entry, expires: expires})
+	for l.ll.Len() > l.size {
+		l.removeElement(l.ll.Back())
+	}
+}
+
+func (l *localCache) delete(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		if el, ok := l.items[key]; ok {
+			l.removeElement(el)
+		}
+	}
+}
+
+func (l *localCache) removeElement(el *list.Element) {
+	l.ll.Remove(el)
+	delete(l.items, el.Value.(*localItem).key)
+}
+
+// subscribeInvalidations drops keys purged on any replica from the local
+// tier until ctx is done. Invalidations missed while Redis is unreachable
+// are bounded by the local TTL.
+func (l *localCache) subscribeInvalidations(ctx context.Context, log *slog.Logger, client *redis.Client) {
+	sub := client.Subscribe(ctx, cacheInvalidateChannel)
+	go func() {
+		defer sub.Close()
+		ch := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				var keys []string
+				if err := json.Unmarshal([]byte(msg.Payload), &keys); err != nil {
+					log.Error("Invalid cache invalidation message", sl.Err(err))
+					continue
+				}
+				l.delete(keys...)
+			}
+		}
+	}()
+}
+
+func observeCache(tier, result string) {
+	prometheus2.CacheLookupsTotal.WithLabelValues(tier, result).Inc()
+}
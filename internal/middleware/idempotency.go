@@ -0,0 +1,190 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/Citadelas/api-gateway/internal/config"
+	"github.com/Citadelas/api-gateway/internal/helpers/problem"
+	"github.com/Citadelas/api-gateway/internal/lib/lock"
+	"github.com/Citadelas/api-gateway/internal/lib/logger/sl"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// IdempotencyKeyHeader is the request header carrying the client key.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks responses served from the store.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLen = 255
+)
+
+// idempotencyRecord is a stored response together with the fingerprint of
+// the request that produced it.
+type idempotencyRecord struct {
+	Fingerprint string      `json:"fingerprint"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+}
+
+// IdempotencyMiddleware makes mutations with an Idempotency-Key header safe
+// to retry. The first response is stored in Redis per user and key for
+// cfg.TTL and replayed to requests repeating the key with the same method,
+// path and body. A different request with a used key gets 422, a duplicate
+// sent while the first one is still running gets 409. Server errors, 409
+// and 429 are not stored, so the client can retry them with the same key.
+// Bodies over cfg.MaxBodySize are rejected with 413.
+// It must run after AuthMiddleware. Redis failures let the request through.
+func IdempotencyMiddleware(log *slog.Logger, client *redis.Client, cfg config.Idempotency) gin.HandlerFunc {
+	log = log.With("op", "middleware.Idempotency")
+	locks := lock.New(client)
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" || c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			problem.Abort(c, 400, IdempotencyKeyHeader+" is too long")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, cfg.MaxBodySize))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				problem.Abort(c, 413, "request body is too large")
+				return
+			}
+			problem.Abort(c, 400, "failed to read body")
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := requestFingerprint(c.Request.Method, c.Request.URL.RequestURI(), body)
+		storeKey := idempotencyKey(c.GetUint64("userID"), key)
+
+		if replayed, err := replayIdempotent(c, client, storeKey, fingerprint); err != nil {
+			log.ErrorContext(c, "Failed to read idempotency record", sl.Err(err))
+			c.Next()
+			return
+		} else if replayed {
+			return
+		}
+
+		l, err := locks.Acquire(c, "lock:"+storeKey, cfg.LockTTL)
+		if errors.Is(err, lock.ErrNotAcquired) {
+			problem.Abort(c, 409, "a request with this "+IdempotencyKeyHeader+" is in progress")
+			return
+		}
+		if err != nil {
+			log.ErrorContext(c, "Failed to lock idempotency key", sl.Err(err))
+			c.Next()
+			return
+		}
+		defer func() {
+			if err := l.Release(context.WithoutCancel(c.Request.Context())); err != nil {
+				log.ErrorContext(c, "Failed to unlock idempotency key", sl.Err(err))
+			}
+		}()
+		// The first request may have finished between the lookup and the lock.
+		if replayed, err := replayIdempotent(c, client, storeKey, fingerprint); err == nil && replayed {
+			return
+		}
+
+		bw := newBufferedWriter(c.Writer)
+		c.Writer = bw
+		completed := false
+		// When a handler panics its response is not stored. A response it
+		// already wrote is sent as is, otherwise Recovery writes the 500
+		// to the restored writer.
+		defer func() {
+			if completed {
+				return
+			}
+			c.Writer = bw.ResponseWriter
+			if bw.Written() {
+				bw.flush()
+			}
+		}()
+		c.Next()
+		completed = true
+		c.Writer = bw.ResponseWriter
+
+		if storable(bw.status) {
+			rec := idempotencyRecord{
+				Fingerprint: fingerprint,
+				Status:      bw.status,
+				Header:      bw.header,
+				Body:        bw.body.Bytes(),
+			}
+			data, err := json.Marshal(rec)
+			if err == nil {
+				err = client.Set(c.Request.Context(), storeKey, data, cfg.TTL).Err()
+			}
+			if err != nil {
+				log.ErrorContext(c, "Failed to store idempotency record", sl.Err(err))
+			}
+		}
+		bw.flush()
+	}
+}
+
+// replayIdempotent writes the stored response of storeKey, or 422 when it
+// was stored for a different request. It reports whether it responded.
+func replayIdempotent(c *gin.Context, client *redis.Client, storeKey, fingerprint string) (bool, error) {
+	data, err := client.Get(c.Request.Context(), storeKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var rec idempotencyRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return false, err
+	}
+	if rec.Fingerprint != fingerprint {
+		problem.Abort(c, 422, IdempotencyKeyHeader+" was already used for a different request")
+		return true, nil
+	}
+
+	for k, v := range rec.Header {
+		c.Writer.Header()[k] = v
+	}
+	c.Header(IdempotentReplayedHeader, "true")
+	c.Status(rec.Status)
+	if len(rec.Body) > 0 {
+		_, _ = c.Writer.Write(rec.Body)
+	} else {
+		c.Writer.WriteHeaderNow()
+	}
+	c.Abort()
+	return true, nil
+}
+
+func storable(status int) bool {
+	return status < 500 && status != http.StatusConflict && status != http.StatusTooManyRequests
+}
+
+func requestFingerprint(method, uri string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + uri + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func idempotencyKey(userID uint64, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "idempotency:user:" + strconv.FormatUint(userID, 10) + ":" + hex.EncodeToString(sum[:])
+}
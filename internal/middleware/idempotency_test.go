@@ -0,0 +1,208 @@
+package middleware
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Citadelas/api-gateway/internal/config"
+	"github.com/Citadelas/api-gateway/internal/helpers/problem"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// idempotencyRouter serves POST /tasks behind IdempotencyMiddleware. The
+// handler answers with the status in the "status" query parameter and the
+// number of times it ran; "block" makes it wait on release, "panic" panics.
+func idempotencyRouter(t *testing.T, calls *atomic.Int32, entered, release chan struct{}) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	cfg := config.Idempotency{TTL: time.Hour, LockTTL: time.Minute, MaxBodySize: 64}
+	router := gin.New()
+	router.Use(gin.CustomRecovery(problem.Recovery))
+	router.Use(func(c *gin.Context) {
+		c.Set("userID", uint64(1))
+		c.Next()
+	})
+	router.Use(IdempotencyMiddleware(slog.New(slog.NewTextHandler(io.Discard, nil)), client, cfg))
+	router.POST("/tasks", func(c *gin.Context) {
+		n := calls.Add(1)
+		if c.Query("block") != "" {
+			entered <- struct{}{}
+			<-release
+		}
+		if c.Query("panic") != "" {
+			panic("boom")
+		}
+		status, _ := strconv.Atoi(c.DefaultQuery("status", "201"))
+		c.Header("X-Handler", "yes")
+		c.String(status, "call %d", n)
+	})
+	return router
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	type request struct {
+		key, uri, body string
+		wantStatus     int
+		wantBody       string
+		wantReplayed   bool
+	}
+	tests := []struct {
+		name      string
+		requests  []request
+		wantCalls int32
+	}{
+		{
+			name: "retry is replayed",
+			requests: []request{
+				{key: "a", uri: "/tasks", body: `{"title":"x"}`, wantStatus: 201, wantBody: "call 1"},
+				{key: "a", uri: "/tasks", body: `{"title":"x"}`, wantStatus: 201, wantBody: "call 1", wantReplayed: true},
+			},
+			wantCalls: 1,
+		},
+		{
+			name: "key reused for another body",
+			requests: []request{
+				{key: "a", uri: "/tasks", body: `{"title":"x"}`, wantStatus: 201, wantBody: "call 1"},
+				{key: "a", uri: "/tasks", body: `{"title":"y"}`, wantStatus: 422},
+			},
+			wantCalls: 1,
+		},
+		{
+			name: "key reused for another URI",
+			requests: []request{
+				{key: "a", uri: "/tasks", body: `{}`, wantStatus: 201, wantBody: "call 1"},
+				{key: "a", uri: "/tasks?status=200", body: `{}`, wantStatus: 422},
+			},
+			wantCalls: 1,
+		},
+		{
+			name: "different keys run twice",
+			requests: []request{
+				{key: "a", uri: "/tasks", body: `{}`, wantStatus: 201, wantBody: "call 1"},
+				{key: "b", uri: "/tasks", body: `{}`, wantStatus: 201, wantBody: "call 2"},
+			},
+			wantCalls: 2,
+		},
+		{
+			name: "client errors are stored",
+			requests: []request{
+				{key: "a", uri: "/tasks?status=400", body: `{}`, wantStatus: 400, wantBody: "call 1"},
+				{key: "a", uri: "/tasks?status=400", body: `{}`, wantStatus: 400, wantBody: "call 1", wantReplayed: true},
+			},
+			wantCalls: 1,
+		},
+		{
+			name: "server errors, conflicts and rate limits are retried",
+			requests: []request{
+				{key: "a", uri: "/tasks?status=503", body: `{}`, wantStatus: 503, wantBody: "call 1"},
+				{key: "a", uri: "/tasks?status=503", body: `{}`, wantStatus: 503, wantBody: "call 2"},
+				{key: "b", uri: "/tasks?status=409", body: `{}`, wantStatus: 409, wantBody: "call 3"},
+				{key: "b", uri: "/tasks?status=409", body: `{}`, wantStatus: 409, wantBody: "call 4"},
+				{key: "c", uri: "/tasks?status=429", body: `{}`, wantStatus: 429, wantBody: "call 5"},
+				{key: "c", uri: "/tasks?status=429", body: `{}`, wantStatus: 429, wantBody: "call 6"},
+			},
+			wantCalls: 6,
+		},
+		{
+			name: "requests without a key are not deduplicated",
+			requests: []request{
+				{uri: "/tasks", body: `{}`, wantStatus: 201, wantBody: "call 1"},
+				{uri: "/tasks", body: `{}`, wantStatus: 201, wantBody: "call 2"},
+			},
+			wantCalls: 2,
+		},
+		{
+			name: "too long key",
+			requests: []request{
+				{key: strings.Repeat("k", maxIdempotencyKeyLen+1), uri: "/tasks", body: `{}`, wantStatus: 400},
+			},
+		},
+		{
+			name: "too large body",
+			requests: []request{
+				{key: "a", uri: "/tasks", body: strings.Repeat("x", 65), wantStatus: 413},
+			},
+		},
+		{
+			name: "panic is answered with 500 and not stored",
+			requests: []request{
+				{key: "a", uri: "/tasks?panic=1", body: `{}`, wantStatus: 500},
+				{key: "a", uri: "/tasks?panic=1", body: `{}`, wantStatus: 500},
+			},
+			wantCalls: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			router := idempotencyRouter(t, &calls, nil, nil)
+			for i, r := range tt.requests {
+				req := httptest.NewRequest(http.MethodPost, r.uri, strings.NewReader(r.body))
+				if r.key != "" {
+					req.Header.Set(IdempotencyKeyHeader, r.key)
+				}
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				if w.Code != r.wantStatus {
+					t.Fatalf("request %d: status = %d, want %d: %s", i, w.Code, r.wantStatus, w.Body)
+				}
+				if r.wantBody != "" && w.Body.String() != r.wantBody {
+					t.Errorf("request %d: body = %q, want %q", i, w.Body, r.wantBody)
+				}
+				if replayed := w.Header().Get(IdempotentReplayedHeader) == "true"; replayed != r.wantReplayed {
+					t.Errorf("request %d: replayed = %v, want %v", i, replayed, r.wantReplayed)
+				}
+				if r.wantReplayed && w.Header().Get("X-Handler") != "yes" {
+					t.Errorf("request %d: replay lost the handler headers", i)
+				}
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("handler calls = %d, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestIdempotencyMiddlewareInFlight(t *testing.T) {
+	var calls atomic.Int32
+	entered, release := make(chan struct{}), make(chan struct{})
+	router := idempotencyRouter(t, &calls, entered, release)
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/tasks?block=1", strings.NewReader(`{}`))
+		req.Header.Set(IdempotencyKeyHeader, "a")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- send() }()
+	<-entered
+
+	if w := send(); w.Code != 409 {
+		t.Fatalf("duplicate in flight: status = %d, want 409", w.Code)
+	}
+	close(release)
+	if w := <-first; w.Code != 201 {
+		t.Fatalf("first request: status = %d, want 201", w.Code)
+	}
+	if w := send(); w.Code != 201 || w.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Fatalf("retry after completion: status = %d, replayed = %q", w.Code, w.Header().Get(IdempotentReplayedHeader))
+	}
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("handler calls = %d, want 1", got)
+	}
+}
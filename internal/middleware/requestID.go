@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Citadelas/api-gateway/internal/lib/requestid"
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDKey is the gin context key of the request ID.
+const RequestIDKey = "requestID"
+
+// RequestIDMiddleware takes the request ID from the X-Request-ID header or
+// generates one, echoes it in the response and stores it in the request
+// context, where loggers and gRPC clients pick it up.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+		c.Set(RequestIDKey, id)
+		c.Request = c.Request.WithContext(requestid.NewContext(c.Request.Context(), id))
+		c.Header(requestid.Header, id)
+		c.Next()
+	}
+}
+
+// AccessLogFormatter is the gin access log format with the request ID.
+func AccessLogFormatter(p gin.LogFormatterParams) string {
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v | request_id=%s\n%s",
+		p.TimeStamp.Format(time.RFC3339),
+		p.StatusCode,
+		p.Latency,
+		p.ClientIP,
+		p.Method,
+		p.Path,
+		p.Keys[RequestIDKey],
+		p.ErrorMessage,
+	)
+}
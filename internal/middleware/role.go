@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Citadelas/api-gateway/internal/helpers/problem"
+	"github.com/Citadelas/api-gateway/internal/lib/jwt"
+	"github.com/Citadelas/api-gateway/internal/lib/logger/sl"
+	ssov1 "github.com/Citadelas/protos/golang/sso"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+const RoleAdmin = "admin"
+
+// RoleResolver resolves caller roles from token claims. The admin role is
+// additionally resolved through SSO IsAdmin and cached in Redis.
+type RoleResolver struct {
+	log       *slog.Logger
+	ssoClient ssov1.AuthClient
+	redis     *redis.Client
+	ttl       time.Duration
+}
+
+func NewRoleResolver(log *slog.Logger, ssoClient ssov1.AuthClient, client *redis.Client, ttl time.Duration) *RoleResolver {
+	return &RoleResolver{
+		log:       log.With("op", "middleware.RoleResolver"),
+		ssoClient: ssoClient,
+		redis:     client,
+		ttl:       ttl,
+	}
+}
+
+// HasRole reports whether the token owner has the role.
+func (r *RoleResolver) HasRole(ctx context.Context, claims *jwt.CustomClaims, role string) (bool, error) {
+	if slices.Contains(claims.Roles, role) {
+		return true, nil
+	}
+	if role != RoleAdmin {
+		return false, nil
+	}
+	if claims.IsAdmin {
+		return true, nil
+	}
+	return r.isAdmin(ctx, claims.UserID)
+}
+
+func (r *RoleResolver) isAdmin(ctx context.Context, userID uint64) (bool, error) {
+	key := "roles:admin:" + strconv.FormatUint(userID, 10)
+
+	cached, err := r.redis.Get(ctx, key).Bool()
+	if err == nil {
+		return cached, nil
+	}
+	if !errors.Is(err, redis.Nil) {
+		r.log.ErrorContext(ctx, "Failed to read role cache", sl.Err(err))
+	}
+
+	resp, err := r.ssoClient.IsAdmin(ctx, &ssov1.IsAdminRequest{UserId: int64(userID)})
+	if err != nil {
+		return false, fmt.Errorf("resolve admin role: %w", err)
+	}
+	if err := r.redis.Set(ctx, key, resp.GetIsAdmin(), r.ttl).Err(); err != nil {
+		r.log.ErrorContext(ctx, "Failed to save role cache", sl.Err(err))
+	}
+	return resp.GetIsAdmin(), nil
+}
+
+// RequireRole allows the request if the caller has any of the roles.
+// It must run after AuthMiddleware.
+func RequireRole(resolver *RoleResolver, roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := c.MustGet("claims").(*jwt.CustomClaims)
+		for _, role := range roles {
+			ok, err := resolver.HasRole(c.Request.Context(), claims, role)
+			if err != nil {
+				problem.Abort(c, 503, "failed to resolve roles")
+				return
+			}
+			if ok {
+				c.Next()
+				return
+			}
+		}
+		problem.Abort(c, 403, "insufficient role")
+	}
+}
+
+func RequireAdmin(resolver *RoleResolver) gin.HandlerFunc {
+	return RequireRole(resolver, RoleAdmin)
+}